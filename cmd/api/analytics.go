@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/see-air-uh/finn-mrkrabs/data"
+)
+
+// GetCashFlow returns the cash flow of an account per ?interval= week, month
+// or quarter between ?from= and ?to=, both inclusive. It defaults to the last
+// twelve months.
+func (app *Config) GetCashFlow(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+
+	interval := r.URL.Query().Get("interval")
+	if interval == "" {
+		interval = data.CashFlowMonth
+	}
+
+	from, to, err := parseDateRange(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	if to == nil {
+		tomorrow := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+		to = &tomorrow
+	}
+	if from == nil {
+		start := to.AddDate(-1, 0, 0)
+		from = &start
+	}
+
+	periods, err := app.Models.Transaction.GetCashFlow(u, account, interval, *from, *to)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Retrieved cash flow per %s for user %s", interval, u),
+		Data:    periods,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func (app *Config) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+
+	keys, err := app.Models.APIKey.GetAPIKeys(u)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Retrieved api keys for user %s", u),
+		Data:    keys,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	var requestPayload struct {
+		Name      string     `json:"name"`
+		Accounts  []string   `json:"accounts"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expiresAt"`
+	}
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	key, plain, err := app.Models.APIKey.CreateAPIKey(u, requestPayload.Name, requestPayload.Accounts, requestPayload.Scopes, requestPayload.ExpiresAt)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Created api key for user %s. Store the key now, it will not be shown again", u),
+		Data: struct {
+			Key    string `json:"key"`
+			APIKey any    `json:"apiKey"`
+		}{plain, key},
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	keyIDString := chi.URLParam(r, "keyID")
+
+	keyID, err := strconv.Atoi(keyIDString)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	err = app.Models.APIKey.RevokeAPIKey(u, keyID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Revoked api key %s for user %s", keyIDString, u),
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/see-air-uh/finn-mrkrabs/data"
+)
+
+// attachmentURLTTL is how long a signed download link stays valid.
+const attachmentURLTTL = 15 * time.Minute
+
+// newBlobStore returns the store for attachments configured by BLOB_STORE:
+// files below BLOB_DIR, the default, or a bucket of an S3 compatible service.
+func newBlobStore() (data.BlobStore, error) {
+	switch os.Getenv("BLOB_STORE") {
+	case "", "local":
+		root := os.Getenv("BLOB_DIR")
+		if root == "" {
+			root = "attachments"
+		}
+		return &data.LocalBlobStore{Root: root}, nil
+	case "s3":
+		store := &data.S3BlobStore{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			Client:    &http.Client{Timeout: 30 * time.Second},
+		}
+		if store.Endpoint == "" {
+			store.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", store.Region)
+		}
+		if store.Region == "" || store.Bucket == "" {
+			return nil, errors.New("BLOB_STORE=s3 needs S3_REGION and S3_BUCKET")
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown BLOB_STORE %s", os.Getenv("BLOB_STORE"))
+	}
+}
+
+// newAttachmentKey returns the key signing download links, from
+// ATTACHMENT_URL_SECRET. Without one, links only last until a restart.
+func newAttachmentKey() ([]byte, error) {
+	if secret := os.Getenv("ATTACHMENT_URL_SECRET"); secret != "" {
+		return []byte(secret), nil
+	}
+	log.Println("No ATTACHMENT_URL_SECRET configured, attachment links will not survive a restart")
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (app *Config) attachmentSignature(attachmentID int, expires int64) string {
+	h := hmac.New(sha256.New, app.AttachmentKey)
+	fmt.Fprintf(h, "%d|%d", attachmentID, expires)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// signAttachment sets a download link to an attachment that expires after
+// attachmentURLTTL.
+func (app *Config) signAttachment(a *data.Attachment) {
+	expiresAt := time.Now().Add(attachmentURLTTL).UTC().Truncate(time.Second)
+	expires := expiresAt.Unix()
+	a.URL = fmt.Sprintf("/attachments/%d?expires=%d&signature=%s", a.AttachmentID, expires, app.attachmentSignature(a.AttachmentID, expires))
+	a.URLExpiresAt = &expiresAt
+}
+
+// readAttachment returns the name and content of the file uploaded with a
+// request, either as the "file" field of a multipart form or as the whole
+// body, named by the filename query parameter.
+func readAttachment(w http.ResponseWriter, r *http.Request) (string, []byte, error) {
+	// leave room for the multipart framing around the file
+	r.Body = http.MaxBytesReader(w, r.Body, data.MaxAttachmentSize+1<<20)
+
+	var name string
+	var file io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(maxUploadBytes); err != nil {
+			return "", nil, uploadError(err)
+		}
+		f, header, err := r.FormFile("file")
+		if err != nil {
+			return "", nil, err
+		}
+		defer f.Close()
+		name, file = header.Filename, f
+	} else {
+		name = r.URL.Query().Get("filename")
+	}
+
+	content, err := io.ReadAll(io.LimitReader(file, data.MaxAttachmentSize+1))
+	if err != nil {
+		return "", nil, uploadError(err)
+	}
+	if len(content) > data.MaxAttachmentSize {
+		return "", nil, data.ErrAttachmentTooLarge
+	}
+	return name, content, nil
+}
+
+// uploadError reports a body cut off by http.MaxBytesReader as too large.
+func uploadError(err error) error {
+	if strings.Contains(err.Error(), "request body too large") {
+		return data.ErrAttachmentTooLarge
+	}
+	return err
+}
+
+// attachmentOwner reads the transaction or debt an attachment route is about.
+func attachmentOwner(r *http.Request) (*int, *int, error) {
+	if v := chi.URLParam(r, "transactionID"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &id, nil, nil
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "debtID"))
+	if err != nil {
+		return nil, nil, err
+	}
+	return nil, &id, nil
+}
+
+// GetAttachments lists the attachments of a transaction or a debt, each with
+// a fresh download link.
+func (app *Config) GetAttachments(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	transactionID, debtID, err := attachmentOwner(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	attachments, err := app.Models.Attachment.GetAttachments(u, account, transactionID, debtID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	for i := range attachments {
+		app.signAttachment(&attachments[i])
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Retrieved %d attachments for user %s", len(attachments), u),
+		Data:    attachments,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// CreateAttachment uploads a receipt or document to a transaction or a debt.
+func (app *Config) CreateAttachment(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	transactionID, debtID, err := attachmentOwner(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	name, content, err := readAttachment(w, r)
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+
+	attachment, err := app.Models.Attachment.CreateAttachment(u, account, transactionID, debtID, name, content)
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+	app.signAttachment(&attachment)
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Attached %s for user %s", attachment.FileName, u),
+		Data:    attachment,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) GetAttachment(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	attachmentID, err := strconv.Atoi(chi.URLParam(r, "attachmentID"))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	attachment, err := app.Models.Attachment.GetAttachment(u, account, attachmentID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	app.signAttachment(&attachment)
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Retrieved attachment %d for user %s", attachmentID, u),
+		Data:    attachment,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) DeleteAttachment(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	attachmentID, err := strconv.Atoi(chi.URLParam(r, "attachmentID"))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Models.Attachment.DeleteAttachment(u, account, attachmentID)
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Deleted attachment %d for user %s", attachmentID, u),
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// DownloadAttachment serves the content of an attachment to whoever holds a
+// signed link that has not expired yet.
+func (app *Config) DownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	attachmentID, err := strconv.Atoi(chi.URLParam(r, "attachmentID"))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	expires, err := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid or expired link"), http.StatusForbidden)
+		return
+	}
+	signature := r.URL.Query().Get("signature")
+	if !hmac.Equal([]byte(signature), []byte(app.attachmentSignature(attachmentID, expires))) || time.Now().Unix() > expires {
+		app.errorJSON(w, errors.New("invalid or expired link"), http.StatusForbidden)
+		return
+	}
+
+	attachment, err := app.Models.Attachment.GetAttachmentByID(attachmentID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusNotFound)
+		return
+	}
+	content, err := attachment.Open(r.Context())
+	if errors.Is(err, data.ErrBlobNotFound) {
+		app.errorJSON(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename*=UTF-8''%s", url.PathEscape(attachment.FileName)))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("ETag", `"`+attachment.SHA256+`"`)
+	w.Header().Set("Cache-Control", "private, max-age=900")
+	if _, err := io.Copy(w, content); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/see-air-uh/finn-mrkrabs/data"
+)
+
+type contextKey string
+
+const (
+	userContextKey   contextKey = "user"
+	apiKeyContextKey contextKey = "apikey"
+)
+
+// Authenticator validates bearer tokens. HS256 tokens are checked against a
+// shared secret and RS256 tokens against the keys of a local JWKS file.
+type Authenticator struct {
+	secret   []byte
+	keys     map[string]*rsa.PublicKey
+	issuer   string
+	audience string
+}
+
+type jwks struct {
+	Keys []struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+func newAuthenticator() (*Authenticator, error) {
+	auth := &Authenticator{
+		secret:   []byte(os.Getenv("JWT_SECRET")),
+		keys:     map[string]*rsa.PublicKey{},
+		issuer:   os.Getenv("JWT_ISSUER"),
+		audience: os.Getenv("JWT_AUDIENCE"),
+	}
+
+	if path := os.Getenv("JWT_JWKS_FILE"); path != "" {
+		if err := auth.loadJWKS(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(auth.secret) == 0 && len(auth.keys) == 0 {
+		return nil, errors.New("no JWT_SECRET or JWT_JWKS_FILE configured")
+	}
+
+	return auth, nil
+}
+
+func (a *Authenticator) loadJWKS(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var set jwks
+	if err := json.Unmarshal(b, &set); err != nil {
+		return err
+	}
+
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return fmt.Errorf("jwks key %s: %w", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return fmt.Errorf("jwks key %s: %w", k.Kid, err)
+		}
+		a.keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return nil
+}
+
+func (a *Authenticator) keyFunc(token *jwt.Token) (interface{}, error) {
+	switch token.Method.Alg() {
+	case jwt.SigningMethodHS256.Alg():
+		if len(a.secret) == 0 {
+			return nil, errors.New("HS256 tokens are not accepted")
+		}
+		return a.secret, nil
+	case jwt.SigningMethodRS256.Alg():
+		kid, _ := token.Header["kid"].(string)
+		key, ok := a.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+}
+
+// Subject parses and validates a raw token and returns its subject.
+func (a *Authenticator) Subject(raw string) (string, error) {
+	var claims jwt.RegisteredClaims
+
+	_, err := jwt.ParseWithClaims(raw, &claims, a.keyFunc, jwt.WithValidMethods([]string{"HS256", "RS256"}))
+	if err != nil {
+		return "", err
+	}
+	if a.issuer != "" && !claims.VerifyIssuer(a.issuer, true) {
+		return "", errors.New("token has invalid issuer")
+	}
+	if a.audience != "" && !claims.VerifyAudience(a.audience, true) {
+		return "", errors.New("token has invalid audience")
+	}
+	if claims.Subject == "" {
+		return "", errors.New("token has no subject")
+	}
+	return claims.Subject, nil
+}
+
+// authenticate rejects requests without a valid bearer token and stores the
+// authenticated user in the request context. The bearer token is either a
+// JWT, whose subject is the user, or an API key, which is also stored so that
+// requireScope can check what it was granted.
+func (app *Config) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		raw := strings.TrimPrefix(header, "Bearer ")
+		if header == "" || raw == header {
+			app.errorJSON(w, errors.New("missing bearer token"), http.StatusUnauthorized)
+			return
+		}
+
+		ctx := r.Context()
+		if strings.HasPrefix(raw, data.APIKeyPrefix) {
+			key, err := app.Models.APIKey.Authenticate(raw)
+			if err != nil {
+				app.errorJSON(w, err, http.StatusUnauthorized)
+				return
+			}
+			ctx = context.WithValue(ctx, userContextKey, key.UserID)
+			ctx = context.WithValue(ctx, apiKeyContextKey, &key)
+		} else {
+			user, err := app.Auth.Subject(raw)
+			if err != nil {
+				app.errorJSON(w, err, http.StatusUnauthorized)
+				return
+			}
+			ctx = context.WithValue(ctx, userContextKey, user)
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireScope lets API keys through only when they hold scope and were
+// granted the {account} of the route. User tokens are always let through.
+func (app *Config) requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := authAPIKey(r)
+			if key != nil {
+				if !key.HasScope(scope) {
+					app.errorJSON(w, fmt.Errorf("api key is missing scope %s", scope), http.StatusForbidden)
+					return
+				}
+				if !key.HasAccount(chi.URLParam(r, "account")) {
+					app.errorJSON(w, errors.New("api key is not allowed to access this account"), http.StatusForbidden)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireUserToken rejects API keys on routes that no scope covers.
+func (app *Config) requireUserToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authAPIKey(r) != nil {
+			app.errorJSON(w, errors.New("api keys can not access this route"), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requirePathUser rejects requests whose {user} path segment is not the
+// authenticated user.
+func (app *Config) requirePathUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if chi.URLParam(r, "user") != authUser(r) {
+			app.errorJSON(w, errors.New("user does not match token"), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authUser returns the authenticated user for the request.
+func authUser(r *http.Request) string {
+	u, _ := r.Context().Value(userContextKey).(string)
+	return u
+}
+
+// authAPIKey returns the API key used to authenticate the request, or nil
+// when the request was authenticated with a JWT.
+func authAPIKey(r *http.Request) *data.APIKey {
+	k, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return k
+}
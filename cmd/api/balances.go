@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// GetBalanceHistory returns the end of day balances of an account between
+// ?from= and ?to=, both inclusive. The range defaults to the last 30 days.
+func (app *Config) GetBalanceHistory(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+
+	from, to, err := parseDateRange(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	if to == nil {
+		tomorrow := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+		to = &tomorrow
+	}
+	if from == nil {
+		start := to.AddDate(0, 0, -30)
+		from = &start
+	}
+
+	balances, err := app.Models.Transaction.GetDailyBalances(u, account, *from, *to)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Grabbed balance history for user %s", u),
+		Data:    balances,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
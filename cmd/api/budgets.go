@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// budgetMonth returns the month query parameter, defaulting to the current
+// month.
+func budgetMonth(r *http.Request) string {
+	month := r.URL.Query().Get("month")
+	if month == "" {
+		month = time.Now().Format("2006-01")
+	}
+	return month
+}
+
+func (app *Config) GetBudgets(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	month := budgetMonth(r)
+
+	budgets, err := app.Models.Budget.GetBudgets(u, account, month)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Retrieved budgets of %s for user %s", month, u),
+		Data:    budgets,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) SetBudget(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	var requestPayload struct {
+		Category string  `json:"category"`
+		Month    string  `json:"month"`
+		Amount   float32 `json:"amount"`
+		Rollover bool    `json:"rollover"`
+	}
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	budget, err := app.Models.Budget.SetBudget(u, account, requestPayload.Category, requestPayload.Month, requestPayload.Amount, requestPayload.Rollover)
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Set budget for %s in %s for user %s", budget.Category, budget.Month, u),
+		Data:    budget,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) DeleteBudget(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	budgetID, err := strconv.Atoi(chi.URLParam(r, "budgetID"))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Models.Budget.DeleteBudget(u, account, budgetID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Deleted budget %d for user %s", budgetID, u),
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) GetBudgetReport(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	month := budgetMonth(r)
+
+	report, err := app.Models.Budget.GetBudgetReport(u, account, month)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Retrieved budget report of %s for user %s", month, u),
+		Data:    report,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type categoryPayload struct {
+	Name     string `json:"name"`
+	Colour   string `json:"colour"`
+	Icon     string `json:"icon"`
+	ParentID *int   `json:"parentID"`
+}
+
+func (app *Config) GetCategoryList(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+
+	categories, err := app.Models.Category.GetCategories(u, account)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Retrieved categories for user %s", u),
+		Data:    categories,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) CreateCategory(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	var requestPayload categoryPayload
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	category, err := app.Models.Category.CreateCategory(u, account, requestPayload.Name, requestPayload.Colour, requestPayload.Icon, requestPayload.ParentID)
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Created category %s for user %s", category.TransactionCategory, u),
+		Data:    category,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) UpdateCategory(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	categoryID, err := strconv.Atoi(chi.URLParam(r, "categoryID"))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	var requestPayload categoryPayload
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	category, err := app.Models.Category.UpdateCategory(u, account, categoryID, requestPayload.Name, requestPayload.Colour, requestPayload.Icon, requestPayload.ParentID)
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Updated category %d for user %s", categoryID, u),
+		Data:    category,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) MergeCategories(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	categoryID, err := strconv.Atoi(chi.URLParam(r, "categoryID"))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	var requestPayload struct {
+		Into int `json:"into"`
+	}
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	n, err := app.Models.Category.MergeCategories(u, account, categoryID, requestPayload.Into)
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Merged category %d into %d, re-tagged %d transactions", categoryID, requestPayload.Into, n),
+		Data:    n,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) DeleteCategory(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	categoryID, err := strconv.Atoi(chi.URLParam(r, "categoryID"))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var reassignTo *int
+	if v := r.URL.Query().Get("reassign_to"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+		reassignTo = &id
+	}
+
+	n, err := app.Models.Category.DeleteCategory(u, account, categoryID, reassignTo)
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Deleted category %d, re-tagged %d transactions", categoryID, n),
+		Data:    n,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) GetCategorySummary(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	from, to, err := parseDateRange(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	summary, err := app.Models.Category.GetCategorySummary(u, account, from, to)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Retrieved category summary for user %s", u),
+		Data:    summary,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func (app *Config) SetEnvelopeMode(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	var requestPayload struct {
+		Enabled bool `json:"enabled"`
+	}
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	updated, err := app.Models.Account.SetEnvelopeMode(u, account, requestPayload.Enabled)
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Updated envelope mode for user %s", u),
+		Data:    updated,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) GetEnvelopes(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+
+	date := time.Now().UTC().Truncate(24 * time.Hour)
+	if v := r.URL.Query().Get("date"); v != "" {
+		d, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+		date = d
+	}
+
+	summary, err := app.Models.Account.GetEnvelopes(u, account, date)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Retrieved envelopes of %s for user %s", summary.Date, u),
+		Data:    summary,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) MoveEnvelopeMoney(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	var requestPayload struct {
+		From   string  `json:"from"`
+		To     string  `json:"to"`
+		Amount float32 `json:"amount"`
+	}
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	summary, err := app.Models.Account.MoveEnvelopeMoney(u, account, requestPayload.From, requestPayload.To, requestPayload.Amount)
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Moved %.2f between envelopes for user %s", requestPayload.Amount, u),
+		Data:    summary,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
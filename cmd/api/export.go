@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/see-air-uh/finn-mrkrabs/data"
+)
+
+// exportWriter writes an export in one format.
+type exportWriter interface {
+	data.ExportWriter
+	Close() error
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// exportCell formats a value of an export as text.
+func exportCell(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', 2, 32)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// csvExport writes a single dataset as CSV with a header row.
+type csvExport struct {
+	w *csv.Writer
+}
+
+func (e *csvExport) Begin(dataset string, columns []string) error {
+	return e.w.Write(columns)
+}
+
+func (e *csvExport) Row(values []any) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		record[i] = exportCell(v)
+	}
+	return e.w.Write(record)
+}
+
+func (e *csvExport) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// ndjsonExport writes one JSON object per line, naming the dataset of each
+// row, so datasets can follow each other in one file.
+type ndjsonExport struct {
+	w       io.Writer
+	dataset string
+	columns []string
+}
+
+func (e *ndjsonExport) Begin(dataset string, columns []string) error {
+	e.dataset, e.columns = dataset, columns
+	return nil
+}
+
+func (e *ndjsonExport) Row(values []any) error {
+	// written by hand to keep the columns in order
+	var b strings.Builder
+	b.WriteString(`{"dataset":`)
+	name, _ := json.Marshal(e.dataset)
+	b.Write(name)
+	for i, v := range values {
+		value, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		key, _ := json.Marshal(e.columns[i])
+		b.WriteByte(',')
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(e.w, b.String())
+	return err
+}
+
+func (e *ndjsonExport) Close() error {
+	return nil
+}
+
+// ofxExport writes the transactions of an account as an OFX 1.0.2 bank
+// statement, with the balance of the account as its ledger balance.
+// Transaction ids are used as FITIDs.
+type ofxExport struct {
+	w        io.Writer
+	account  string
+	currency string
+	now      string
+	started  bool
+	balance  float32
+}
+
+func (e *ofxExport) Begin(dataset string, columns []string) error {
+	if dataset != data.ExportTransactions {
+		return fmt.Errorf("OFX only holds transactions, not %s", dataset)
+	}
+	_, err := fmt.Fprintf(e.w, "OFXHEADER:100\r\nDATA:OFXSGML\r\nVERSION:102\r\nSECURITY:NONE\r\nENCODING:USASCII\r\nCHARSET:1252\r\n"+
+		"COMPRESSION:NONE\r\nOLDFILEUID:NONE\r\nNEWFILEUID:NONE\r\n\r\n"+
+		"<OFX>\n<SIGNONMSGSRSV1><SONRS><STATUS><CODE>0<SEVERITY>INFO</STATUS><DTSERVER>%s<LANGUAGE>ENG</SONRS></SIGNONMSGSRSV1>\n"+
+		"<BANKMSGSRSV1><STMTTRNRS><TRNUID>0<STATUS><CODE>0<SEVERITY>INFO</STATUS>\n"+
+		"<STMTRS><CURDEF>%s<BANKACCTFROM><BANKID>0<ACCTID>%s<ACCTTYPE>CHECKING</BANKACCTFROM>\n",
+		e.now, e.currency, xmlEscape(e.account))
+	return err
+}
+
+// list starts the transaction list, which opens with the date of the first
+// transaction.
+func (e *ofxExport) list(start string) error {
+	e.started = true
+	_, err := fmt.Fprintf(e.w, "<BANKTRANLIST><DTSTART>%s<DTEND>%s\n", start, e.now)
+	return err
+}
+
+func (e *ofxExport) Row(values []any) error {
+	id, date, amount := values[0].(int), values[1].(time.Time), values[2].(float32)
+	name, description := values[3].(string), values[4].(string)
+	if !e.started {
+		if err := e.list(date.UTC().Format("20060102150405")); err != nil {
+			return err
+		}
+	}
+	e.balance += amount
+
+	trnType := "CREDIT"
+	if amount < 0 {
+		trnType = "DEBIT"
+	}
+	// names are limited to 32 characters
+	if r := []rune(name); len(r) > 32 {
+		name = string(r[:32])
+	}
+	_, err := fmt.Fprintf(e.w, "<STMTTRN><TRNTYPE>%s<DTPOSTED>%s<TRNAMT>%.2f<FITID>%d<NAME>%s<MEMO>%s</STMTTRN>\n",
+		trnType, date.UTC().Format("20060102150405"), amount, id, xmlEscape(name), xmlEscape(description))
+	return err
+}
+
+func (e *ofxExport) Close() error {
+	if !e.started {
+		if err := e.list(e.now); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(e.w, "</BANKTRANLIST><LEDGERBAL><BALAMT>%.2f<DTASOF>%s</LEDGERBAL>\n</STMTRS></STMTTRNRS></BANKMSGSRSV1>\n</OFX>\n",
+		e.balance, e.now)
+	return err
+}
+
+// exportResponse notes whether anything was sent, after which an error can
+// no longer be answered with an error response.
+type exportResponse struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (r *exportResponse) Write(b []byte) (int, error) {
+	r.started = true
+	return r.ResponseWriter.Write(b)
+}
+
+// exportDatasets reads ?datasets=, a comma separated list which defaults to
+// every dataset.
+func exportDatasets(r *http.Request) []string {
+	v := r.URL.Query().Get("datasets")
+	if v == "" {
+		return data.ExportDatasets
+	}
+	return strings.Split(v, ",")
+}
+
+// ExportAccount streams the data of an account in the format given by
+// ?format=: ndjson (the default) or xlsx with every dataset, csv with a
+// single one, or ofx with the transactions.
+func (app *Config) ExportAccount(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "ndjson"
+	}
+	datasets := exportDatasets(r)
+
+	res := &exportResponse{ResponseWriter: w}
+	buf := bufio.NewWriterSize(res, 32*1024)
+
+	var out exportWriter
+	var contentType string
+	switch format {
+	case "ndjson":
+		out, contentType = &ndjsonExport{w: buf}, "application/x-ndjson"
+	case "xlsx":
+		out, contentType = newXLSXWriter(buf), "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case "csv":
+		if r.URL.Query().Get("datasets") == "" {
+			datasets = []string{data.ExportTransactions}
+		}
+		if len(datasets) != 1 {
+			app.errorJSON(w, errors.New("a CSV export holds a single dataset"), http.StatusBadRequest)
+			return
+		}
+		out, contentType = &csvExport{w: csv.NewWriter(buf)}, "text/csv"
+	case "ofx":
+		currency := r.URL.Query().Get("currency")
+		if currency == "" {
+			currency = "USD"
+		}
+		datasets = []string{data.ExportTransactions}
+		out, contentType = &ofxExport{w: buf, account: account, currency: currency, now: time.Now().UTC().Format("20060102150405")}, "application/x-ofx"
+	default:
+		app.errorJSON(w, fmt.Errorf("unknown export format %q", format), http.StatusBadRequest)
+		return
+	}
+
+	filename := fmt.Sprintf("%s-%s.%s", account, time.Now().Format("2006-01-02"), format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	err := app.Models.Transaction.Export(u, account, datasets, out)
+	if err == nil {
+		err = out.Close()
+	}
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err != nil && !res.started {
+		w.Header().Del("Content-Disposition")
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		// the client gets a cut off file
+		log.Println("export of", account, "for", u, "failed:", err)
+	}
+}
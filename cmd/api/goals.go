@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type goalPayload struct {
+	Name         string  `json:"name"`
+	TargetAmount float32 `json:"targetAmount"`
+	TargetDate   string  `json:"targetDate"`
+	Category     *string `json:"category"`
+	AutoFund     bool    `json:"autoFund"`
+}
+
+func (app *Config) GetGoals(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+
+	goals, err := app.Models.Goal.GetGoals(u, account)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Retrieved savings goals for user %s", u),
+		Data:    goals,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) GetGoal(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	goalID, err := strconv.Atoi(chi.URLParam(r, "goalID"))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	goal, err := app.Models.Goal.GetGoal(u, account, goalID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Retrieved savings goal %d for user %s", goalID, u),
+		Data:    goal,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) CreateGoal(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	var requestPayload goalPayload
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	goal, err := app.Models.Goal.CreateGoal(u, account, requestPayload.Name, requestPayload.TargetAmount, requestPayload.TargetDate, requestPayload.Category, requestPayload.AutoFund)
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Created savings goal %d for user %s", goal.Goal.GoalID, u),
+		Data:    goal,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) UpdateGoal(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	goalID, err := strconv.Atoi(chi.URLParam(r, "goalID"))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	var requestPayload goalPayload
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	goal, err := app.Models.Goal.UpdateGoal(u, account, goalID, requestPayload.Name, requestPayload.TargetAmount, requestPayload.TargetDate, requestPayload.Category)
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Updated savings goal %d for user %s", goalID, u),
+		Data:    goal,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) DeleteGoal(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	goalID, err := strconv.Atoi(chi.URLParam(r, "goalID"))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Models.Goal.DeleteGoal(u, account, goalID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Deleted savings goal %d for user %s", goalID, u),
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
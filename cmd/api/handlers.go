@@ -1,19 +1,35 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/see-air-uh/finn-mrkrabs/data"
 )
 
+// GetBalance returns the current balance of an account, or its balance at the
+// end of the day given by ?as_of=2006-01-02.
 func (app *Config) GetBalance(w http.ResponseWriter, r *http.Request) {
-	u := chi.URLParam(r, "user")
+	u := authUser(r)
 	account := chi.URLParam(r, "account")
 
-	balance, err := app.Models.Transaction.GetUserBalance(u, account)
+	var balance float32
+	var err error
+	if asOf := r.URL.Query().Get("as_of"); asOf != "" {
+		var day time.Time
+		day, err = time.Parse("2006-01-02", asOf)
+		if err == nil {
+			balance, err = app.Models.Transaction.GetBalanceAsOf(u, account, day.AddDate(0, 0, 1))
+		}
+	} else {
+		balance, err = app.Models.Transaction.GetUserBalance(u, account)
+	}
 	if err != nil {
 		app.errorJSON(w, err, http.StatusBadRequest)
 		return
@@ -29,7 +45,7 @@ func (app *Config) GetBalance(w http.ResponseWriter, r *http.Request) {
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
 func (app *Config) UpdateTransactionCategory(w http.ResponseWriter, r *http.Request) {
-	u := chi.URLParam(r, "user")
+	u := authUser(r)
 	account := chi.URLParam(r, "account")
 
 	var requestPayload struct {
@@ -44,7 +60,7 @@ func (app *Config) UpdateTransactionCategory(w http.ResponseWriter, r *http.Requ
 
 	err = app.Models.Transaction.UpdateTransactionCategory(u, account, requestPayload.TransactionID, requestPayload.TransactionCategory)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, err, errorStatus(err))
 		return
 	}
 	app.writeJSON(w, http.StatusAccepted, jsonResponse{
@@ -54,7 +70,7 @@ func (app *Config) UpdateTransactionCategory(w http.ResponseWriter, r *http.Requ
 }
 func (app *Config) GetCategories(w http.ResponseWriter, r *http.Request) {
 	log.Println("Got categories")
-	u := chi.URLParam(r, "user")
+	u := authUser(r)
 	account := chi.URLParam(r, "account")
 	categories, err := app.Models.Transaction.GetAllCategories(u, account)
 	if err != nil {
@@ -69,24 +85,25 @@ func (app *Config) GetCategories(w http.ResponseWriter, r *http.Request) {
 	})
 }
 func (app *Config) UpdateBalance(w http.ResponseWriter, r *http.Request) {
-	u := chi.URLParam(r, "user")
+	u := authUser(r)
 	account := chi.URLParam(r, "account")
 	var requestPayload struct {
 		// Username          string  `json:"username"`
-		TransactionAmount      float32 `json:"transactionAmount"`
-		TransactionName        string  `json:"transactionName"`
-		TransactionDescription string  `json:"transactionDescription"`
-		TransactionCategory    string  `json:"transactionCategory"`
+		TransactionAmount      float32            `json:"transactionAmount"`
+		TransactionName        string             `json:"transactionName"`
+		TransactionDescription string             `json:"transactionDescription"`
+		TransactionCategory    string             `json:"transactionCategory"`
+		Recurring              bool               `json:"recurring"`
+		Envelopes              map[string]float32 `json:"envelopes"`
 	}
 	err := app.readJSON(w, r, &requestPayload)
 	if err != nil {
 		app.errorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-	balance, err := app.Models.Transaction.UpdateBalance(u, account, requestPayload.TransactionAmount, requestPayload.TransactionName, requestPayload.TransactionDescription, requestPayload.TransactionCategory)
+	balance, err := app.Models.Transaction.UpdateBalance(u, account, requestPayload.TransactionAmount, requestPayload.TransactionName, requestPayload.TransactionDescription, requestPayload.TransactionCategory, requestPayload.Recurring, requestPayload.Envelopes)
 	if err != nil {
-
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, err, errorStatus(err))
 		return
 	}
 	payload := jsonResponse{
@@ -97,10 +114,13 @@ func (app *Config) UpdateBalance(w http.ResponseWriter, r *http.Request) {
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
 func (app *Config) GetAllTransactions(w http.ResponseWriter, r *http.Request) {
-	u := chi.URLParam(r, "user")
+	u := authUser(r)
 	account := chi.URLParam(r, "account")
 
-	transactions, err := app.Models.Transaction.GetAllTransactions(u, account)
+	tags := r.URL.Query()["tag"]
+	matchAll := r.URL.Query().Get("match") == "all"
+
+	transactions, err := app.Models.Transaction.GetAllTransactions(u, account, tags, matchAll)
 	if err != nil {
 		app.errorJSON(w, err, http.StatusBadRequest)
 		return
@@ -113,11 +133,12 @@ func (app *Config) GetAllTransactions(w http.ResponseWriter, r *http.Request) {
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
 func (app *Config) GetAllTransactionsOfCategory(w http.ResponseWriter, r *http.Request) {
-	u := chi.URLParam(r, "user")
+	u := authUser(r)
 	account := chi.URLParam(r, "account")
 	c := chi.URLParam(r, "category")
+	includeDescendants := r.URL.Query().Get("descendants") == "true"
 
-	transactions, err := app.Models.Transaction.GetAllTransactionsOfCategory(u, account, c)
+	transactions, err := app.Models.Transaction.GetAllTransactionsOfCategory(u, account, c, includeDescendants)
 	if err != nil {
 		app.errorJSON(w, err, http.StatusBadRequest)
 		return
@@ -130,7 +151,7 @@ func (app *Config) GetAllTransactionsOfCategory(w http.ResponseWriter, r *http.R
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
 func (app *Config) GetReccurringPayments(w http.ResponseWriter, r *http.Request) {
-	u := chi.URLParam(r, "user")
+	u := authUser(r)
 	account := chi.URLParam(r, "account")
 
 	balance, err := app.Models.RecurringPayment.GetReccurringPayments(u, account)
@@ -149,7 +170,7 @@ func (app *Config) GetReccurringPayments(w http.ResponseWriter, r *http.Request)
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
 func (app *Config) GetAllReccurringPayments(w http.ResponseWriter, r *http.Request) {
-	u := chi.URLParam(r, "user")
+	u := authUser(r)
 
 	balance, err := app.Models.RecurringPayment.GetAllReccurringPayments()
 	if err != nil {
@@ -167,7 +188,7 @@ func (app *Config) GetAllReccurringPayments(w http.ResponseWriter, r *http.Reque
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
 func (app *Config) AddReccurringPayment(w http.ResponseWriter, r *http.Request) {
-	u := chi.URLParam(r, "user")
+	u := authUser(r)
 	account := chi.URLParam(r, "account")
 	var requestPayload struct {
 		PaymentAmount      float32 `json:"amount"`
@@ -184,7 +205,7 @@ func (app *Config) AddReccurringPayment(w http.ResponseWriter, r *http.Request)
 	}
 	balance, err := app.Models.RecurringPayment.AddReccurringPayment(u, account, requestPayload.PaymentAmount, requestPayload.PaymentName, requestPayload.PaymentDescription, requestPayload.PaymentDate, requestPayload.PaymentType, requestPayload.PaymentFrequency)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, err, errorStatus(err))
 		return
 	}
 	payload := jsonResponse{
@@ -196,7 +217,7 @@ func (app *Config) AddReccurringPayment(w http.ResponseWriter, r *http.Request)
 }
 
 func (app *Config) GetPaymentHistory(w http.ResponseWriter, r *http.Request) {
-	u := chi.URLParam(r, "user")
+	u := authUser(r)
 	r_id := chi.URLParam(r, "recurring_id")
 
 	recurring_id, err := strconv.Atoi(r_id)
@@ -206,7 +227,7 @@ func (app *Config) GetPaymentHistory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	transactions, err := app.Models.PaymentHistory.GetPaymentHistory(recurring_id)
+	transactions, err := app.Models.PaymentHistory.GetPaymentHistory(u, recurring_id)
 	if err != nil {
 		app.errorJSON(w, err, http.StatusBadRequest)
 		return
@@ -220,9 +241,10 @@ func (app *Config) GetPaymentHistory(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Config) GetUserAccounts(w http.ResponseWriter, r *http.Request) {
-	u := chi.URLParam(r, "user")
+	u := authUser(r)
+	includeInactive := r.URL.Query().Get("archived") == "true"
 
-	accounts, err := app.Models.Account.GetUserAccounts(u)
+	accounts, err := app.Models.Account.GetUserAccounts(u, includeInactive)
 	if err != nil {
 		app.errorJSON(w, err, http.StatusBadRequest)
 		return
@@ -236,7 +258,7 @@ func (app *Config) GetUserAccounts(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Config) GetAllDebts(w http.ResponseWriter, r *http.Request) {
-	u := chi.URLParam(r, "user")
+	u := authUser(r)
 	account := chi.URLParam(r, "account")
 	debts, err := app.Models.Debt.GetAllDebts(u, account)
 	if err != nil {
@@ -253,7 +275,7 @@ func (app *Config) GetAllDebts(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Config) CreateDebt(w http.ResponseWriter, r *http.Request) {
-	u := chi.URLParam(r, "user")
+	u := authUser(r)
 	account := chi.URLParam(r, "account")
 	var debtPayload struct {
 		TotalOwing float32 `json:"total_owing"`
@@ -266,7 +288,7 @@ func (app *Config) CreateDebt(w http.ResponseWriter, r *http.Request) {
 	}
 	debt, err := app.Models.Debt.CreateDebt(u, account, debtPayload.TotalOwing, debtPayload.Name)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, err, errorStatus(err))
 		return
 	}
 	payload := jsonResponse{
@@ -278,7 +300,7 @@ func (app *Config) CreateDebt(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Config) GetDebtByID(w http.ResponseWriter, r *http.Request) {
-	u := chi.URLParam(r, "user")
+	u := authUser(r)
 	account := chi.URLParam(r, "account")
 	debtIDString := chi.URLParam(r, "debtID")
 
@@ -301,7 +323,7 @@ func (app *Config) GetDebtByID(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Config) MakeDebtPayment(w http.ResponseWriter, r *http.Request) {
-	u := chi.URLParam(r, "user")
+	u := authUser(r)
 	account := chi.URLParam(r, "account")
 	debtIDString := chi.URLParam(r, "debtID")
 
@@ -321,7 +343,7 @@ func (app *Config) MakeDebtPayment(w http.ResponseWriter, r *http.Request) {
 	debt, err := app.Models.Debt.MakeDebtPayment(u, account, debtID, debtPayload.Amount)
 
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, err, errorStatus(err))
 		return
 	}
 	payload := jsonResponse{
@@ -333,10 +355,21 @@ func (app *Config) MakeDebtPayment(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Config) AddAccount(w http.ResponseWriter, r *http.Request) {
-	u := chi.URLParam(r, "user")
+	u := authUser(r)
 	account := chi.URLParam(r, "account")
+	var requestPayload struct {
+		AccountType            string  `json:"accountType"`
+		CreditLimit            float32 `json:"creditLimit"`
+		MonthlyWithdrawalLimit int     `json:"monthlyWithdrawalLimit"`
+	}
+	// the body is optional, accounts default to chequing
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil && !errors.Is(err, io.EOF) {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
 
-	_, err := app.Models.Account.AddAccount(u, account)
+	_, err = app.Models.Account.AddAccount(u, account, requestPayload.AccountType, requestPayload.CreditLimit, requestPayload.MonthlyWithdrawalLimit)
 	if err != nil {
 		app.errorJSON(w, err, http.StatusBadRequest)
 		return
@@ -349,11 +382,21 @@ func (app *Config) AddAccount(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Config) AddUserToAccount(w http.ResponseWriter, r *http.Request) {
-	u := chi.URLParam(r, "user")
+	u := authUser(r)
 	account := chi.URLParam(r, "account")
 	u2 := chi.URLParam(r, "user2")
 
-	_, err := app.Models.Account.AddUserToAccount(u2, account)
+	member, err := app.Models.Account.IsMember(u, account)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	if !member {
+		app.errorJSON(w, errors.New("user is not a member of this account"), http.StatusForbidden)
+		return
+	}
+
+	_, err = app.Models.Account.AddUserToAccount(u, u2, account)
 	if err != nil {
 		app.errorJSON(w, err, http.StatusBadRequest)
 		return
@@ -365,3 +408,154 @@ func (app *Config) AddUserToAccount(w http.ResponseWriter, r *http.Request) {
 	}
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
+
+func (app *Config) UpdateAccountType(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	var requestPayload struct {
+		AccountType            string  `json:"accountType"`
+		CreditLimit            float32 `json:"creditLimit"`
+		MonthlyWithdrawalLimit int     `json:"monthlyWithdrawalLimit"`
+	}
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	updated, err := app.Models.Account.UpdateAccountType(u, account, requestPayload.AccountType, requestPayload.CreditLimit, requestPayload.MonthlyWithdrawalLimit)
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Updated account type for user %s", u),
+		Data:    updated,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) UpdateBalancePolicy(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	var policy data.BalancePolicy
+	err := app.readJSON(w, r, &policy)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	updated, err := app.Models.Account.UpdateBalancePolicy(u, account, policy)
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Updated balance policy for user %s", u),
+		Data:    updated,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) RenameAccount(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	var requestPayload struct {
+		Name string `json:"name"`
+	}
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	renamed, err := app.Models.Account.RenameAccount(u, account, requestPayload.Name)
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Renamed account %s to %s for user %s", account, renamed.AccountName, u),
+		Data:    renamed,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) UndoRenameAccount(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+
+	restored, err := app.Models.Account.UndoRenameAccount(u, account)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Renamed account %s back to %s for user %s", account, restored.AccountName, u),
+		Data:    restored,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) ArchiveAccount(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+
+	archived, err := app.Models.Account.ArchiveAccount(u, account)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Archived account %s for user %s", account, u),
+		Data:    archived,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) CloseAccount(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	var requestPayload struct {
+		ClosingTransaction bool `json:"closingTransaction"`
+	}
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil && !errors.Is(err, io.EOF) {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	closed, err := app.Models.Account.CloseAccount(u, account, requestPayload.ClosingTransaction)
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Closed account %s for user %s", account, u),
+		Data:    closed,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) RestoreAccount(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+
+	restored, err := app.Models.Account.RestoreAccount(u, account)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Restored account %s for user %s", account, u),
+		Data:    restored,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
@@ -5,6 +5,9 @@ import (
 	"errors"
 	"io"
 	"net/http"
+	"time"
+
+	"github.com/see-air-uh/finn-mrkrabs/data"
 )
 
 type jsonResponse struct {
@@ -72,3 +75,52 @@ func (app *Config) errorJSON(w http.ResponseWriter, err error, status ...int) er
 
 	return app.writeJSON(w, statusCode, payload)
 }
+
+// errorStatus picks the response status for an error returned by the models.
+// Transactions refused by an account's balance rules or tagged with an unknown
+// category, and imports with rows that can not be imported, are a 422. Changes
+// to an archived or closed account or to a reconciled transaction are a 409,
+// anything else is treated as a bad request.
+func errorStatus(err error) int {
+	var balanceErr *data.BalanceError
+	if errors.As(err, &balanceErr) || errors.Is(err, data.ErrUnknownCategory) || errors.Is(err, data.ErrImportRejected) {
+		return http.StatusUnprocessableEntity
+	}
+	if errors.Is(err, data.ErrAccountReadOnly) || errors.Is(err, data.ErrTransactionReconciled) {
+		return http.StatusConflict
+	}
+	if errors.Is(err, data.ErrAttachmentTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	if errors.Is(err, data.ErrAttachmentType) {
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusBadRequest
+}
+
+// parseDateRange reads the optional from and to query parameters, formatted as
+// 2006-01-02. Both days are included, so the returned end is the start of the
+// day after to.
+func parseDateRange(r *http.Request) (*time.Time, *time.Time, error) {
+	var from, to *time.Time
+
+	if v := r.URL.Query().Get("from"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return nil, nil, err
+		}
+		from = &t
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return nil, nil, err
+		}
+		t = t.AddDate(0, 0, 1)
+		to = &t
+	}
+	if from != nil && to != nil && !from.Before(*to) {
+		return nil, nil, errors.New("from must not be after to")
+	}
+	return from, to, nil
+}
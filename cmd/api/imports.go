@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/see-air-uh/finn-mrkrabs/data"
+)
+
+// maxUploadBytes bounds the size of an uploaded file.
+const maxUploadBytes = 10 << 20
+
+// readUpload returns the file uploaded with a request, either as the "file"
+// field of a multipart form or as the whole body.
+func readUpload(w http.ResponseWriter, r *http.Request) (io.ReadCloser, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(maxUploadBytes); err != nil {
+			return nil, err
+		}
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			return nil, err
+		}
+		return file, nil
+	}
+	return r.Body, nil
+}
+
+// writeImportResult answers an import. An import refused because of rows that
+// can not be imported still returns the rows, so the errors can be shown.
+func (app *Config) writeImportResult(w http.ResponseWriter, u string, result data.ImportResult, err error) {
+	if errors.Is(err, data.ErrImportRejected) {
+		payload := jsonResponse{
+			Error:   true,
+			Message: err.Error(),
+			Data:    result,
+		}
+		app.writeJSON(w, errorStatus(err), payload)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+
+	message := fmt.Sprintf("Imported %d transactions for user %s, skipped %d duplicates", result.Imported, u, result.Duplicates)
+	if result.DryRun {
+		message = fmt.Sprintf("Import would add %d transactions for user %s, skip %d duplicates and fail on %d rows", result.Imported, u, result.Duplicates, result.Failed)
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: message,
+		Data:    result,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) GetImportMappings(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+
+	mappings, err := app.Models.ImportMapping.GetImportMappings(u, account)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Retrieved import mappings for user %s", u),
+		Data:    mappings,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) CreateImportMapping(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	var requestPayload data.ImportMapping
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	mapping, err := app.Models.ImportMapping.CreateImportMapping(u, account, requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Created import mapping %d for user %s", mapping.MappingID, u),
+		Data:    mapping,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) DeleteImportMapping(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	mappingID, err := strconv.Atoi(chi.URLParam(r, "mappingID"))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Models.ImportMapping.DeleteImportMapping(u, account, mappingID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Deleted import mapping %d for user %s", mappingID, u),
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// ImportCSV imports a CSV file read with the mapping given by ?mapping=.
+// With ?dry_run=true nothing is saved.
+func (app *Config) ImportCSV(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+	mappingID, err := strconv.Atoi(r.URL.Query().Get("mapping"))
+	if err != nil {
+		app.errorJSON(w, errors.New("a mapping is required"), http.StatusBadRequest)
+		return
+	}
+
+	file, err := readUpload(w, r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	result, err := app.Models.Transaction.ImportCSV(u, account, mappingID, file, dryRun)
+	app.writeImportResult(w, u, result, err)
+}
+
+// ImportOFX imports an OFX or QFX statement. With ?dry_run=true nothing is
+// saved.
+func (app *Config) ImportOFX(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	file, err := readUpload(w, r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	result, err := app.Models.Transaction.ImportOFX(u, account, file, dryRun)
+	app.writeImportResult(w, u, result, err)
+}
+
+// ImportQIF imports a QIF file. Dates are read month first unless
+// ?day_first=true is given. With ?dry_run=true nothing is saved.
+func (app *Config) ImportQIF(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+	dayFirst := r.URL.Query().Get("day_first") == "true"
+
+	file, err := readUpload(w, r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	result, err := app.Models.Transaction.ImportQIF(u, account, file, dayFirst, dryRun)
+	app.writeImportResult(w, u, result, err)
+}
+
+// ImportCAMT imports a camt.053 statement. A file with the statements of
+// several accounts needs ?iban= to choose one. With ?dry_run=true nothing is
+// saved.
+func (app *Config) ImportCAMT(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+	iban := r.URL.Query().Get("iban")
+
+	file, err := readUpload(w, r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	result, err := app.Models.Transaction.ImportCAMT(u, account, file, iban, dryRun)
+	app.writeImportResult(w, u, result, err)
+}
@@ -15,8 +15,10 @@ import (
 )
 
 type Config struct {
-	DB     *sql.DB
-	Models data.Models
+	DB            *sql.DB
+	Models        data.Models
+	Auth          *Authenticator
+	AttachmentKey []byte
 }
 
 const (
@@ -33,9 +35,27 @@ func main() {
 
 	conn := connectToDB()
 
+	auth, err := newAuthenticator()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	blobs, err := newBlobStore()
+	if err != nil {
+		log.Panic(err)
+	}
+	data.UseBlobStore(blobs)
+
+	attachmentKey, err := newAttachmentKey()
+	if err != nil {
+		log.Panic(err)
+	}
+
 	app := Config{
-		DB:     conn,
-		Models: data.New(conn),
+		DB:            conn,
+		Models:        data.New(conn),
+		Auth:          auth,
+		AttachmentKey: attachmentKey,
 	}
 
 	srv := &http.Server{
@@ -43,7 +63,7 @@ func main() {
 		Handler: app.routes(),
 	}
 
-	err := srv.ListenAndServe()
+	err = srv.ListenAndServe()
 
 	if err != nil {
 		log.Panic(err)
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type assetPayload struct {
+	Name  string  `json:"name"`
+	Value float32 `json:"value"`
+}
+
+// GetNetWorth returns the net worth of the user with a history of the last
+// ?months= months, 12 by default.
+func (app *Config) GetNetWorth(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+
+	months := 12
+	if v := r.URL.Query().Get("months"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+		months = n
+	}
+
+	netWorth, err := app.Models.Account.GetNetWorth(u, months)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Retrieved net worth for user %s", u),
+		Data:    netWorth,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) GetAssets(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+
+	assets, err := app.Models.Asset.GetAssets(u)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Retrieved assets for user %s", u),
+		Data:    assets,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) CreateAsset(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	var requestPayload assetPayload
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	asset, err := app.Models.Asset.CreateAsset(u, requestPayload.Name, requestPayload.Value)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Created asset %d for user %s", asset.AssetID, u),
+		Data:    asset,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) UpdateAsset(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	assetID, err := strconv.Atoi(chi.URLParam(r, "assetID"))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	var requestPayload assetPayload
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	asset, err := app.Models.Asset.UpdateAsset(u, assetID, requestPayload.Name, requestPayload.Value)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Updated asset %d for user %s", assetID, u),
+		Data:    asset,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) DeleteAsset(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	assetID, err := strconv.Atoi(chi.URLParam(r, "assetID"))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Models.Asset.DeleteAsset(u, assetID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Deleted asset %d for user %s", assetID, u),
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/see-air-uh/finn-mrkrabs/data"
+)
+
+func (app *Config) GetPayees(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+
+	payees, err := app.Models.Payee.GetPayees(u, account)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Retrieved payees for user %s", u),
+		Data:    payees,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) GetPayee(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	payeeID, err := strconv.Atoi(chi.URLParam(r, "payeeID"))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	payee, err := app.Models.Payee.GetPayee(u, account, payeeID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Retrieved payee %d for user %s", payeeID, u),
+		Data:    payee,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) CreatePayee(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	var requestPayload data.Payee
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	payee, err := app.Models.Payee.CreatePayee(u, account, requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Created payee %d for user %s", payee.PayeeID, u),
+		Data:    payee,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) UpdatePayee(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	payeeID, err := strconv.Atoi(chi.URLParam(r, "payeeID"))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	var requestPayload data.Payee
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	payee, err := app.Models.Payee.UpdatePayee(u, account, payeeID, requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Updated payee %d for user %s", payeeID, u),
+		Data:    payee,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) DeletePayee(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	payeeID, err := strconv.Atoi(chi.URLParam(r, "payeeID"))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Models.Payee.DeletePayee(u, account, payeeID)
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Deleted payee %d for user %s", payeeID, u),
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// AssignPayees matches the existing transactions of an account to its payees.
+func (app *Config) AssignPayees(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	overwrite := r.URL.Query().Get("overwrite") == "true"
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	changes, err := app.Models.Payee.AssignPayees(u, account, overwrite, dryRun)
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+	message := fmt.Sprintf("Assigned payees for user %s, changed %d transactions", u, len(changes))
+	if dryRun {
+		message = fmt.Sprintf("Assigning payees for user %s would change %d transactions", u, len(changes))
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: message,
+		Data:    changes,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// SetTransactionPayee assigns a transaction to a payee by hand. A null
+// payeeID takes it off its payee.
+func (app *Config) SetTransactionPayee(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	transactionID, err := strconv.Atoi(chi.URLParam(r, "transactionID"))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	var requestPayload struct {
+		PayeeID *int `json:"payeeID"`
+	}
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Models.Payee.SetTransactionPayee(u, account, transactionID, requestPayload.PayeeID)
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Set payee of transaction %d for user %s", transactionID, u),
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) GetPayeeTotals(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+
+	from, to, err := parseDateRange(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	totals, err := app.Models.Payee.GetPayeeTotals(u, account, from, to)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Retrieved payee totals for user %s", u),
+		Data:    totals,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) GetPayeeHistory(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	payeeID, err := strconv.Atoi(chi.URLParam(r, "payeeID"))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	from, to, err := parseDateRange(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	history, err := app.Models.Payee.GetPayeeHistory(u, account, payeeID, from, to)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Retrieved history of payee %d for user %s", payeeID, u),
+		Data:    history,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// SetCleared marks the transaction in the path as cleared, or not.
+func (app *Config) SetCleared(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	transactionID, err := strconv.Atoi(chi.URLParam(r, "transactionID"))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	var requestPayload struct {
+		Cleared bool `json:"cleared"`
+	}
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Models.Transaction.SetCleared(u, account, transactionID, requestPayload.Cleared)
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Updated transaction %d for user %s", transactionID, u),
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) GetReconciliations(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+
+	reconciliations, err := app.Models.Reconciliation.GetReconciliations(u, account)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Retrieved reconciliations for user %s", u),
+		Data:    reconciliations,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) CreateReconciliation(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	var requestPayload struct {
+		StatementDate    string  `json:"statementDate"`
+		StatementBalance float32 `json:"statementBalance"`
+	}
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	statementDate, err := time.Parse("2006-01-02", requestPayload.StatementDate)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	rec, err := app.Models.Reconciliation.CreateReconciliation(u, account, statementDate, requestPayload.StatementBalance)
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Started reconciliation %d for user %s", rec.ReconciliationID, u),
+		Data:    rec,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// reconciliationID reads the reconciliation in the path.
+func reconciliationID(r *http.Request) (int, error) {
+	return strconv.Atoi(chi.URLParam(r, "reconciliationID"))
+}
+
+func (app *Config) GetReconciliation(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	id, err := reconciliationID(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	rec, err := app.Models.Reconciliation.GetReconciliation(u, account, id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Retrieved reconciliation %d for user %s", id, u),
+		Data:    rec,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) TickTransactions(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	id, err := reconciliationID(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	var requestPayload struct {
+		TransactionIDs []int `json:"transactionIDs"`
+		Ticked         bool  `json:"ticked"`
+	}
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	rec, err := app.Models.Reconciliation.TickTransactions(u, account, id, requestPayload.TransactionIDs, requestPayload.Ticked)
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Reconciliation %d is %.2f off the statement for user %s", id, rec.Difference, u),
+		Data:    rec,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) LockReconciliation(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	id, err := reconciliationID(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	rec, err := app.Models.Reconciliation.LockReconciliation(u, account, id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Locked reconciliation %d for user %s", id, u),
+		Data:    rec,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) UnlockReconciliation(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	id, err := reconciliationID(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	rec, err := app.Models.Reconciliation.UnlockReconciliation(u, account, id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Unlocked reconciliation %d for user %s", id, u),
+		Data:    rec,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) DeleteReconciliation(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	id, err := reconciliationID(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Models.Reconciliation.DeleteReconciliation(u, account, id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Deleted reconciliation %d for user %s", id, u),
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
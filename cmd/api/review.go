@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func (app *Config) GetReviewQueue(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+
+	queue, err := app.Models.Transaction.GetReviewQueue(u, account)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Retrieved %d flagged transactions for user %s", len(queue), u),
+		Data:    queue,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// ClearReview clears the flags of the transaction in the path, or of every
+// flagged transaction of the account when there is none.
+func (app *Config) ClearReview(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+
+	var transactionID *int
+	if v := chi.URLParam(r, "transactionID"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+		transactionID = &id
+	}
+
+	n, err := app.Models.Transaction.ClearReview(u, account, transactionID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Cleared %d flags for user %s", n, u),
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/see-air-uh/finn-mrkrabs/data"
 )
 
 func (app *Config) routes() http.Handler {
@@ -23,26 +24,167 @@ func (app *Config) routes() http.Handler {
 
 	mux.Use(middleware.Heartbeat("/ping"))
 
-	mux.Get("/balance/{user}/{account}", app.GetBalance)
-	mux.Post("/balance/{user}/{account}", app.UpdateBalance)
+	// signed download links carry their own authorization
+	mux.Get("/attachments/{attachmentID}", app.DownloadAttachment)
 
-	mux.Get("/recurring/{user}/{account}", app.GetReccurringPayments)
-	mux.Post("/recurring/add/{user}/{account}", app.AddReccurringPayment)
-	mux.Get("/recurring/history/{user}/{recurring_id}", app.GetPaymentHistory)
+	mux.Group(func(mux chi.Router) {
+		mux.Use(app.authenticate)
 
-	mux.Get("/accounts/{user}", app.GetUserAccounts)
-	mux.Post("/accounts/add/{user}/{account}", app.AddAccount)
-	mux.Post("/accounts/add_user/{user}/{account}/{user2}", app.AddUserToAccount)
+		mux.Route("/me", func(mux chi.Router) {
+			mux.With(app.requireScope(data.ScopeReadBalance)).Get("/accounts/{account}/balance", app.GetBalance)
+			mux.With(app.requireScope(data.ScopeReadBalance)).Get("/accounts/{account}/balance/history", app.GetBalanceHistory)
+			mux.With(app.requireScope(data.ScopePostTransactions)).Post("/accounts/{account}/balance", app.UpdateBalance)
+			mux.With(app.requireScope(data.ScopeManageRecurring)).Get("/accounts/{account}/recurring", app.GetReccurringPayments)
+			mux.With(app.requireScope(data.ScopeManageRecurring)).Post("/accounts/{account}/recurring", app.AddReccurringPayment)
 
-	mux.Get("/transaction/{user}/{account}", app.GetAllTransactions)
-	mux.Post("/transaction/{user}/{account}/category", app.UpdateTransactionCategory)
-	mux.Get("/transaction/{user}/{account}/category", app.GetCategories)
-	mux.Get("/transaction/{user}/{account}/category/{category}", app.GetAllTransactionsOfCategory)
+			mux.Group(func(mux chi.Router) {
+				mux.Use(app.requireUserToken)
 
-	mux.Get("/debt/{user}/{account}", app.GetAllDebts)
-	mux.Post("/debt/{user}/{account}", app.CreateDebt)
-	mux.Get("/debt/{user}/{account}/{debtID}", app.GetDebtByID)
-	mux.Post("/debt/{user}/{account}/{debtID}", app.MakeDebtPayment)
+				mux.Get("/accounts", app.GetUserAccounts)
+				mux.Post("/accounts/{account}", app.AddAccount)
+				mux.Post("/accounts/{account}/users/{user2}", app.AddUserToAccount)
+				mux.Put("/accounts/{account}/type", app.UpdateAccountType)
+				mux.Put("/accounts/{account}/policy", app.UpdateBalancePolicy)
+				mux.Post("/accounts/{account}/rename", app.RenameAccount)
+				mux.Post("/accounts/{account}/rename/undo", app.UndoRenameAccount)
+				mux.Post("/accounts/{account}/archive", app.ArchiveAccount)
+				mux.Post("/accounts/{account}/close", app.CloseAccount)
+				mux.Post("/accounts/{account}/restore", app.RestoreAccount)
+
+				mux.Get("/recurring/{recurring_id}/history", app.GetPaymentHistory)
+
+				mux.Get("/accounts/{account}/transactions", app.GetAllTransactions)
+				mux.Get("/accounts/{account}/statement", app.GetStatement)
+				mux.Get("/accounts/{account}/analytics/cash-flow", app.GetCashFlow)
+				mux.Get("/accounts/{account}/review", app.GetReviewQueue)
+				mux.Post("/accounts/{account}/review/clear", app.ClearReview)
+				mux.Post("/accounts/{account}/review/{transactionID}/clear", app.ClearReview)
+
+				mux.Get("/accounts/{account}/import/mappings", app.GetImportMappings)
+				mux.Post("/accounts/{account}/import/mappings", app.CreateImportMapping)
+				mux.Delete("/accounts/{account}/import/mappings/{mappingID}", app.DeleteImportMapping)
+				mux.Post("/accounts/{account}/import/csv", app.ImportCSV)
+				mux.Post("/accounts/{account}/import/ofx", app.ImportOFX)
+				mux.Post("/accounts/{account}/import/qif", app.ImportQIF)
+				mux.Post("/accounts/{account}/import/camt", app.ImportCAMT)
+
+				mux.Get("/accounts/{account}/export", app.ExportAccount)
+
+				mux.Get("/accounts/{account}/transactions/{transactionID}/splits", app.GetSplits)
+				mux.Put("/accounts/{account}/transactions/{transactionID}/splits", app.SetSplits)
+
+				mux.Put("/accounts/{account}/transactions/{transactionID}/tags", app.SetTransactionTags)
+				mux.Post("/accounts/{account}/transactions/{transactionID}/tags", app.SetTransactionTags)
+				mux.Delete("/accounts/{account}/transactions/{transactionID}/tags/{tag}", app.RemoveTransactionTag)
+				mux.Get("/accounts/{account}/tags", app.GetTagTotals)
+				mux.Put("/accounts/{account}/tags/{tag}", app.RenameTag)
+				mux.Delete("/accounts/{account}/tags/{tag}", app.DeleteTag)
+
+				mux.Put("/accounts/{account}/transactions/{transactionID}/cleared", app.SetCleared)
+				mux.Get("/accounts/{account}/reconciliations", app.GetReconciliations)
+				mux.Post("/accounts/{account}/reconciliations", app.CreateReconciliation)
+				mux.Get("/accounts/{account}/reconciliations/{reconciliationID}", app.GetReconciliation)
+				mux.Delete("/accounts/{account}/reconciliations/{reconciliationID}", app.DeleteReconciliation)
+				mux.Post("/accounts/{account}/reconciliations/{reconciliationID}/tick", app.TickTransactions)
+				mux.Post("/accounts/{account}/reconciliations/{reconciliationID}/lock", app.LockReconciliation)
+				mux.Post("/accounts/{account}/reconciliations/{reconciliationID}/unlock", app.UnlockReconciliation)
+
+				mux.Get("/accounts/{account}/transactions/{transactionID}/attachments", app.GetAttachments)
+				mux.Post("/accounts/{account}/transactions/{transactionID}/attachments", app.CreateAttachment)
+				mux.Get("/accounts/{account}/debt/{debtID}/attachments", app.GetAttachments)
+				mux.Post("/accounts/{account}/debt/{debtID}/attachments", app.CreateAttachment)
+				mux.Get("/accounts/{account}/attachments/{attachmentID}", app.GetAttachment)
+				mux.Delete("/accounts/{account}/attachments/{attachmentID}", app.DeleteAttachment)
+
+				mux.Get("/accounts/{account}/payees", app.GetPayees)
+				mux.Post("/accounts/{account}/payees", app.CreatePayee)
+				mux.Get("/accounts/{account}/payees/totals", app.GetPayeeTotals)
+				mux.Post("/accounts/{account}/payees/assign", app.AssignPayees)
+				mux.Get("/accounts/{account}/payees/{payeeID}", app.GetPayee)
+				mux.Put("/accounts/{account}/payees/{payeeID}", app.UpdatePayee)
+				mux.Delete("/accounts/{account}/payees/{payeeID}", app.DeletePayee)
+				mux.Get("/accounts/{account}/payees/{payeeID}/history", app.GetPayeeHistory)
+				mux.Put("/accounts/{account}/transactions/{transactionID}/payee", app.SetTransactionPayee)
+
+				mux.Post("/accounts/{account}/transactions/category", app.UpdateTransactionCategory)
+				mux.Get("/accounts/{account}/transactions/category", app.GetCategories)
+				mux.Get("/accounts/{account}/transactions/category/{category}", app.GetAllTransactionsOfCategory)
+
+				mux.Get("/accounts/{account}/categories", app.GetCategoryList)
+				mux.Post("/accounts/{account}/categories", app.CreateCategory)
+				mux.Get("/accounts/{account}/categories/summary", app.GetCategorySummary)
+				mux.Put("/accounts/{account}/categories/{categoryID}", app.UpdateCategory)
+				mux.Delete("/accounts/{account}/categories/{categoryID}", app.DeleteCategory)
+				mux.Post("/accounts/{account}/categories/{categoryID}/merge", app.MergeCategories)
+
+				mux.Get("/accounts/{account}/rules", app.GetRules)
+				mux.Post("/accounts/{account}/rules", app.CreateRule)
+				mux.Post("/accounts/{account}/rules/apply", app.ApplyRules)
+				mux.Put("/accounts/{account}/rules/{ruleID}", app.UpdateRule)
+				mux.Delete("/accounts/{account}/rules/{ruleID}", app.DeleteRule)
+
+				mux.Get("/accounts/{account}/budgets", app.GetBudgets)
+				mux.Post("/accounts/{account}/budgets", app.SetBudget)
+				mux.Get("/accounts/{account}/budgets/report", app.GetBudgetReport)
+				mux.Delete("/accounts/{account}/budgets/{budgetID}", app.DeleteBudget)
+
+				mux.Put("/accounts/{account}/envelope-mode", app.SetEnvelopeMode)
+				mux.Get("/accounts/{account}/envelopes", app.GetEnvelopes)
+				mux.Post("/accounts/{account}/envelopes/move", app.MoveEnvelopeMoney)
+
+				mux.Get("/accounts/{account}/goals", app.GetGoals)
+				mux.Post("/accounts/{account}/goals", app.CreateGoal)
+				mux.Get("/accounts/{account}/goals/{goalID}", app.GetGoal)
+				mux.Put("/accounts/{account}/goals/{goalID}", app.UpdateGoal)
+				mux.Delete("/accounts/{account}/goals/{goalID}", app.DeleteGoal)
+
+				mux.Get("/accounts/{account}/debt", app.GetAllDebts)
+				mux.Post("/accounts/{account}/debt", app.CreateDebt)
+				mux.Get("/accounts/{account}/debt/{debtID}", app.GetDebtByID)
+				mux.Post("/accounts/{account}/debt/{debtID}", app.MakeDebtPayment)
+
+				mux.Get("/keys", app.GetAPIKeys)
+				mux.Post("/keys", app.CreateAPIKey)
+				mux.Delete("/keys/{keyID}", app.RevokeAPIKey)
+
+				mux.Get("/net-worth", app.GetNetWorth)
+				mux.Get("/assets", app.GetAssets)
+				mux.Post("/assets", app.CreateAsset)
+				mux.Put("/assets/{assetID}", app.UpdateAsset)
+				mux.Delete("/assets/{assetID}", app.DeleteAsset)
+			})
+		})
+
+		// legacy routes that carry the user in the path
+		mux.Group(func(mux chi.Router) {
+			mux.Use(app.requirePathUser)
+
+			mux.With(app.requireScope(data.ScopeReadBalance)).Get("/balance/{user}/{account}", app.GetBalance)
+			mux.With(app.requireScope(data.ScopePostTransactions)).Post("/balance/{user}/{account}", app.UpdateBalance)
+			mux.With(app.requireScope(data.ScopeManageRecurring)).Get("/recurring/{user}/{account}", app.GetReccurringPayments)
+			mux.With(app.requireScope(data.ScopeManageRecurring)).Post("/recurring/add/{user}/{account}", app.AddReccurringPayment)
+
+			mux.Group(func(mux chi.Router) {
+				mux.Use(app.requireUserToken)
+
+				mux.Get("/recurring/history/{user}/{recurring_id}", app.GetPaymentHistory)
+
+				mux.Get("/accounts/{user}", app.GetUserAccounts)
+				mux.Post("/accounts/add/{user}/{account}", app.AddAccount)
+				mux.Post("/accounts/add_user/{user}/{account}/{user2}", app.AddUserToAccount)
+
+				mux.Get("/transaction/{user}/{account}", app.GetAllTransactions)
+				mux.Post("/transaction/{user}/{account}/category", app.UpdateTransactionCategory)
+				mux.Get("/transaction/{user}/{account}/category", app.GetCategories)
+				mux.Get("/transaction/{user}/{account}/category/{category}", app.GetAllTransactionsOfCategory)
+
+				mux.Get("/debt/{user}/{account}", app.GetAllDebts)
+				mux.Post("/debt/{user}/{account}", app.CreateDebt)
+				mux.Get("/debt/{user}/{account}/{debtID}", app.GetDebtByID)
+				mux.Post("/debt/{user}/{account}/{debtID}", app.MakeDebtPayment)
+			})
+		})
+	})
 
 	return mux
 }
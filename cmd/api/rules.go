@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/see-air-uh/finn-mrkrabs/data"
+)
+
+func (app *Config) GetRules(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+
+	rules, err := app.Models.Rule.GetRules(u, account)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Retrieved rules for user %s", u),
+		Data:    rules,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) CreateRule(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	requestPayload := data.Rule{Enabled: true}
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	rule, err := app.Models.Rule.CreateRule(u, account, requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Created rule %d for user %s", rule.RuleID, u),
+		Data:    rule,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) UpdateRule(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	ruleID, err := strconv.Atoi(chi.URLParam(r, "ruleID"))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	requestPayload := data.Rule{Enabled: true}
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	rule, err := app.Models.Rule.UpdateRule(u, account, ruleID, requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Updated rule %d for user %s", ruleID, u),
+		Data:    rule,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) DeleteRule(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	ruleID, err := strconv.Atoi(chi.URLParam(r, "ruleID"))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Models.Rule.DeleteRule(u, account, ruleID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Deleted rule %d for user %s", ruleID, u),
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) ApplyRules(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	overwrite := r.URL.Query().Get("overwrite") == "true"
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	changes, err := app.Models.Rule.ApplyRulesToHistory(u, account, overwrite, dryRun)
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+	message := fmt.Sprintf("Re-applied rules for user %s, changed %d transactions", u, len(changes))
+	if dryRun {
+		message = fmt.Sprintf("Re-applying rules for user %s would change %d transactions", u, len(changes))
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: message,
+		Data:    changes,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
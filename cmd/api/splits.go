@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/see-air-uh/finn-mrkrabs/data"
+)
+
+func (app *Config) GetSplits(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	transactionID, err := strconv.Atoi(chi.URLParam(r, "transactionID"))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	splits, err := app.Models.Transaction.GetSplits(u, account, transactionID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Retrieved splits of transaction %d for user %s", transactionID, u),
+		Data:    splits,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// SetSplits replaces the split lines of a transaction. An empty list removes
+// the split.
+func (app *Config) SetSplits(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	transactionID, err := strconv.Atoi(chi.URLParam(r, "transactionID"))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	var requestPayload struct {
+		Splits []data.Split `json:"splits"`
+	}
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	splits, err := app.Models.Transaction.SetSplits(u, account, transactionID, requestPayload.Splits)
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Split transaction %d into %d lines for user %s", transactionID, len(splits), u),
+		Data:    splits,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/see-air-uh/finn-mrkrabs/data"
+)
+
+// statementPeriod returns the period of a statement: the month given by
+// ?month=2006-01, the days given by ?from= and ?to=, or the current month.
+func statementPeriod(r *http.Request) (time.Time, time.Time, error) {
+	if month := r.URL.Query().Get("month"); month != "" {
+		from, err := data.ParseMonth(month)
+		if err != nil {
+			return from, from, err
+		}
+		return from, from.AddDate(0, 1, 0), nil
+	}
+
+	from, to, err := parseDateRange(r)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if from == nil && to == nil {
+		now := time.Now().UTC()
+		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, 0), nil
+	}
+	if from == nil || to == nil {
+		return time.Time{}, time.Time{}, errors.New("a statement needs both from and to, or a month")
+	}
+	return *from, *to, nil
+}
+
+func (app *Config) GetStatement(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+
+	from, to, err := statementPeriod(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	statement, err := app.Models.Transaction.GetStatement(u, account, from, to)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	filename := fmt.Sprintf("statement-%s-%s", account, from.Format("2006-01-02"))
+	var file bytes.Buffer
+	var contentType string
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		payload := jsonResponse{
+			Error:   false,
+			Message: fmt.Sprintf("Retrieved statement for user %s", u),
+			Data:    statement,
+		}
+		app.writeJSON(w, http.StatusAccepted, payload)
+		return
+	case "csv":
+		contentType, filename = "text/csv", filename+".csv"
+		err = writeStatementCSV(&file, statement)
+	case "pdf":
+		contentType, filename = "application/pdf", filename+".pdf"
+		err = writePDF(&file, statementLines(statement))
+	default:
+		app.errorJSON(w, fmt.Errorf("unknown statement format %q", format), http.StatusBadRequest)
+		return
+	}
+	// a statement covers a bounded period, so it is written out in full before
+	// anything is sent and a failure still gets an error response
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if _, err := file.WriteTo(w); err != nil {
+		log.Println("statement of", account, "for", u, "failed:", err)
+	}
+}
+
+// lastDay returns the last day of a statement, whose end is exclusive.
+func lastDay(s data.Statement) string {
+	return s.To.AddDate(0, 0, -1).Format("2006-01-02")
+}
+
+func money(amount float32) string {
+	return fmt.Sprintf("%.2f", amount)
+}
+
+// writeStatementCSV writes the summary, the categories and the transactions
+// of a statement as three sections separated by blank lines.
+func writeStatementCSV(w io.Writer, s data.Statement) error {
+	out := csv.NewWriter(w)
+
+	records := [][]string{
+		{"account", s.AccountName},
+		{"from", s.From.Format("2006-01-02")},
+		{"to", lastDay(s)},
+		{"opening balance", money(s.OpeningBalance)},
+		{"inflows", money(s.Inflows)},
+		{"outflows", money(s.Outflows)},
+		{"closing balance", money(s.ClosingBalance)},
+		{},
+		{"category", "inflows", "outflows", "net", "count"},
+	}
+	for _, c := range s.Categories {
+		records = append(records, []string{c.Category, money(c.Inflows), money(c.Outflows), money(c.Net), fmt.Sprint(c.Count)})
+	}
+	records = append(records, []string{}, []string{"id", "date", "name", "description", "category", "amount"})
+	for _, t := range s.Transactions {
+		records = append(records, []string{fmt.Sprint(t.TransactionID), t.TransactionDate.Format("2006-01-02"), t.TransactionName, t.TransactionDescription, t.TransactionCategory, money(t.TransactionAmount)})
+	}
+
+	return out.WriteAll(records)
+}
+
+// statementLines lays a statement out as lines of fixed-width text.
+func statementLines(s data.Statement) []string {
+	lines := []string{
+		fmt.Sprintf("Statement of account %s", s.AccountName),
+		fmt.Sprintf("%s to %s", s.From.Format("2006-01-02"), lastDay(s)),
+		"",
+		fmt.Sprintf("%-20s %14s", "Opening balance", money(s.OpeningBalance)),
+		fmt.Sprintf("%-20s %14s", "Inflows", money(s.Inflows)),
+		fmt.Sprintf("%-20s %14s", "Outflows", money(s.Outflows)),
+		fmt.Sprintf("%-20s %14s", "Closing balance", money(s.ClosingBalance)),
+		"",
+		fmt.Sprintf("%-30.30s %14s %14s %14s %6s", "Category", "Inflows", "Outflows", "Net", "Count"),
+	}
+	for _, c := range s.Categories {
+		name := c.Category
+		if name == "" {
+			name = "(uncategorized)"
+		}
+		lines = append(lines, fmt.Sprintf("%-30.30s %14s %14s %14s %6d", name, money(c.Inflows), money(c.Outflows), money(c.Net), c.Count))
+	}
+
+	lines = append(lines, "", fmt.Sprintf("%-10s %-30.30s %-20.20s %14s %14s", "Date", "Name", "Category", "Amount", "Balance"))
+	balance := s.OpeningBalance
+	for _, t := range s.Transactions {
+		balance += t.TransactionAmount
+		lines = append(lines, fmt.Sprintf("%-10s %-30.30s %-20.20s %14s %14s", t.TransactionDate.Format("2006-01-02"), t.TransactionName, t.TransactionCategory, money(t.TransactionAmount), money(balance)))
+	}
+	return lines
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// GetTagTotals returns the tags of an account with the totals of their
+// transactions between ?from= and ?to=.
+func (app *Config) GetTagTotals(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+
+	from, to, err := parseDateRange(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	totals, err := app.Models.Transaction.GetTagTotals(u, account, from, to)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Retrieved tags for user %s", u),
+		Data:    totals,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) RenameTag(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	tag := chi.URLParam(r, "tag")
+	var requestPayload struct {
+		Name string `json:"name"`
+	}
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	n, err := app.Models.Transaction.RenameTag(u, account, tag, requestPayload.Name)
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Renamed tag %s on %d transactions for user %s", tag, n, u),
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) DeleteTag(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	tag := chi.URLParam(r, "tag")
+
+	n, err := app.Models.Transaction.DeleteTag(u, account, tag)
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Removed tag %s from %d transactions for user %s", tag, n, u),
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// SetTransactionTags replaces the tags of a transaction on PUT and adds to
+// them on POST.
+func (app *Config) SetTransactionTags(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	transactionID, err := strconv.Atoi(chi.URLParam(r, "transactionID"))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	var requestPayload struct {
+		Tags []string `json:"tags"`
+	}
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var tags []string
+	if r.Method == http.MethodPost {
+		tags, err = app.Models.Transaction.AddTransactionTags(u, account, transactionID, requestPayload.Tags)
+	} else {
+		tags, err = app.Models.Transaction.SetTransactionTags(u, account, transactionID, requestPayload.Tags)
+	}
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Updated tags of transaction %d for user %s", transactionID, u),
+		Data:    tags,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) RemoveTransactionTag(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	account := chi.URLParam(r, "account")
+	tag := chi.URLParam(r, "tag")
+	transactionID, err := strconv.Atoi(chi.URLParam(r, "transactionID"))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	tags, err := app.Models.Transaction.RemoveTransactionTag(u, account, transactionID, tag)
+	if err != nil {
+		app.errorJSON(w, err, errorStatus(err))
+		return
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Removed tag %s from transaction %d for user %s", tag, transactionID, u),
+		Data:    tags,
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// maxXLSXRows is the number of rows a worksheet can hold.
+const maxXLSXRows = 1048576
+
+// xlsxWriter writes a workbook with one worksheet per dataset of an export.
+// Worksheets are streamed into the zip file as their rows arrive; the parts
+// listing them are written by Close.
+type xlsxWriter struct {
+	zip    *zip.Writer
+	sheet  io.Writer
+	sheets []string
+	rows   int
+}
+
+func newXLSXWriter(w io.Writer) *xlsxWriter {
+	return &xlsxWriter{zip: zip.NewWriter(w)}
+}
+
+func (x *xlsxWriter) endSheet() error {
+	if x.sheet == nil {
+		return nil
+	}
+	_, err := io.WriteString(x.sheet, `</sheetData></worksheet>`)
+	x.sheet = nil
+	return err
+}
+
+func (x *xlsxWriter) Begin(dataset string, columns []string) error {
+	if err := x.endSheet(); err != nil {
+		return err
+	}
+	x.sheets = append(x.sheets, dataset)
+	sheet, err := x.zip.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", len(x.sheets)))
+	if err != nil {
+		return err
+	}
+	x.sheet, x.rows = sheet, 0
+
+	_, err = io.WriteString(x.sheet, xml.Header+`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	if err != nil {
+		return err
+	}
+	header := make([]any, len(columns))
+	for i, c := range columns {
+		header[i] = c
+	}
+	return x.Row(header)
+}
+
+func (x *xlsxWriter) Row(values []any) error {
+	if x.rows++; x.rows > maxXLSXRows {
+		return fmt.Errorf("%s has more rows than a worksheet can hold", x.sheets[len(x.sheets)-1])
+	}
+	if _, err := io.WriteString(x.sheet, "<row>"); err != nil {
+		return err
+	}
+	for _, v := range values {
+		var cell string
+		switch v := v.(type) {
+		case nil:
+			cell = `<c/>`
+		case int:
+			cell = `<c><v>` + strconv.Itoa(v) + `</v></c>`
+		case float32:
+			cell = `<c><v>` + strconv.FormatFloat(float64(v), 'f', -1, 32) + `</v></c>`
+		case bool:
+			b := "0"
+			if v {
+				b = "1"
+			}
+			cell = `<c t="b"><v>` + b + `</v></c>`
+		case time.Time:
+			cell = xlsxString(v.Format("2006-01-02 15:04:05"))
+		default:
+			cell = xlsxString(fmt.Sprint(v))
+		}
+		if _, err := io.WriteString(x.sheet, cell); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(x.sheet, "</row>")
+	return err
+}
+
+func xlsxString(s string) string {
+	return `<c t="inlineStr"><is><t xml:space="preserve">` + xmlEscape(s) + `</t></is></c>`
+}
+
+// Close writes the parts of the workbook that list its worksheets and ends
+// the zip file.
+func (x *xlsxWriter) Close() error {
+	if err := x.endSheet(); err != nil {
+		return err
+	}
+
+	var overrides, sheets, rels string
+	for i, name := range x.sheets {
+		n := i + 1
+		overrides += fmt.Sprintf(`<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, n)
+		sheets += fmt.Sprintf(`<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xmlEscape(name), n, n)
+		rels += fmt.Sprintf(`<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, n, n)
+	}
+
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			overrides + `</Types>`},
+		{"_rels/.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets>` + sheets + `</sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			rels + `</Relationships>`},
+	}
+	for _, p := range parts {
+		f, err := x.zip.Create(p.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, xml.Header+p.body); err != nil {
+			return err
+		}
+	}
+	return x.zip.Close()
+}
@@ -0,0 +1,260 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	AccountStatusOpen     = "open"
+	AccountStatusArchived = "archived"
+	AccountStatusClosed   = "closed"
+)
+
+// AccountGracePeriod is how long a rename, archive or close can be undone.
+const AccountGracePeriod = 30 * 24 * time.Hour
+
+// ErrAccountReadOnly is returned when changing an archived or closed account.
+var ErrAccountReadOnly = errors.New("account is archived or closed and can not be changed")
+
+// accountScopedTables lists the tables whose rows belong to an account, with
+// the column holding their user. Renaming an account moves the rows of every
+// table listed here.
+var accountScopedTables = []struct {
+	table      string
+	userColumn string
+}{
+	{"mrkrabs.Transactions", "username"},
+	{"mrkrabs.Debt", "userid"},
+	{"foreman.recurring_payment", "username"},
+	{"mrkrabs.Category", "username"},
+	{"mrkrabs.Rule", "username"},
+	{"mrkrabs.Budget", "username"},
+	{"mrkrabs.EnvelopeAssignment", "username"},
+	{"mrkrabs.SavingsGoal", "username"},
+	{"mrkrabs.ImportMapping", "username"},
+	{"mrkrabs.Reconciliation", "username"},
+	{"mrkrabs.Attachment", "username"},
+	{"mrkrabs.Payee", "username"},
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// checkWritable returns ErrAccountReadOnly when the account is archived or
+// closed. Account names that were never added are writable.
+func checkWritable(ctx context.Context, q querier, email string, account_name string) error {
+	query := `SELECT status FROM mrkrabs.Account WHERE username = $1 AND accountname = $2`
+
+	var status string
+	err := q.QueryRowContext(ctx, query, email, account_name).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if status != AccountStatusOpen {
+		return ErrAccountReadOnly
+	}
+	return nil
+}
+
+// moveAccountRows renames an account of email from one name to another in
+// every account scoped table.
+func moveAccountRows(ctx context.Context, tx *sql.Tx, email string, from string, to string) error {
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM mrkrabs.Account WHERE username = $1 AND accountname = $2)`
+	if err := tx.QueryRowContext(ctx, query, email, to).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("account %s already exists", to)
+	}
+
+	for _, t := range accountScopedTables {
+		query := fmt.Sprintf(`UPDATE %s SET accountname = $1 WHERE %s = $2 AND accountname = $3`, t.table, t.userColumn)
+		if _, err := tx.ExecContext(ctx, query, to, email, from); err != nil {
+			return err
+		}
+	}
+
+	query = `UPDATE mrkrabs.ApiKey SET Accounts = array_replace(Accounts, $1, $2) WHERE UserID = $3`
+	_, err := tx.ExecContext(ctx, query, from, to, email)
+	return err
+}
+
+// RenameAccount gives an account a new name, keeping its transactions, debts
+// and recurring payments attached.
+func (t *Account) RenameAccount(email string, account_name string, newName string) (Account, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if newName == "" || newName == account_name {
+		return Account{}, errors.New("a new account name is required")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Account{}, err
+	}
+	defer tx.Rollback()
+
+	if err := checkWritable(ctx, tx, email, account_name); err != nil {
+		return Account{}, err
+	}
+	if err := moveAccountRows(ctx, tx, email, account_name, newName); err != nil {
+		return Account{}, err
+	}
+
+	query := `UPDATE mrkrabs.Account
+	SET accountname = $1, previousname = accountname, renamedat = now()
+	WHERE username = $2 AND accountname = $3
+	RETURNING ` + accountColumns
+
+	account, err := scanAccount(tx.QueryRowContext(ctx, query, newName, email, account_name))
+	if err != nil {
+		return account, err
+	}
+	return account, tx.Commit()
+}
+
+// UndoRenameAccount gives an account its previous name back when it was
+// renamed within the grace period.
+func (t *Account) UndoRenameAccount(email string, account_name string) (Account, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Account{}, err
+	}
+	defer tx.Rollback()
+
+	if err := checkWritable(ctx, tx, email, account_name); err != nil {
+		return Account{}, err
+	}
+
+	var previous sql.NullString
+	query := `SELECT previousname FROM mrkrabs.Account
+	WHERE username = $1 AND accountname = $2 AND renamedat > $3`
+	err = tx.QueryRowContext(ctx, query, email, account_name, time.Now().Add(-AccountGracePeriod)).Scan(&previous)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !previous.Valid) {
+		return Account{}, errors.New("account has no rename that can still be undone")
+	}
+	if err != nil {
+		return Account{}, err
+	}
+
+	if err := moveAccountRows(ctx, tx, email, account_name, previous.String); err != nil {
+		return Account{}, err
+	}
+
+	query = `UPDATE mrkrabs.Account
+	SET accountname = previousname, previousname = NULL, renamedat = NULL
+	WHERE username = $1 AND accountname = $2
+	RETURNING ` + accountColumns
+
+	account, err := scanAccount(tx.QueryRowContext(ctx, query, email, account_name))
+	if err != nil {
+		return account, err
+	}
+	return account, tx.Commit()
+}
+
+// ArchiveAccount makes an account read-only and hides it from
+// GetUserAccounts.
+func (t *Account) ArchiveAccount(email string, account_name string) (Account, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	query := `UPDATE mrkrabs.Account
+	SET status = $1, statuschangedat = now()
+	WHERE username = $2 AND accountname = $3 AND status = $4
+	RETURNING ` + accountColumns
+
+	account, err := scanAccount(db.QueryRowContext(ctx, query, AccountStatusArchived, email, account_name, AccountStatusOpen))
+	if errors.Is(err, sql.ErrNoRows) {
+		return account, errors.New("only open accounts can be archived")
+	}
+	return account, err
+}
+
+// CloseAccount closes an account with a zero balance. With
+// closingTransaction set, a transaction bringing the balance to zero is posted
+// first.
+func (t *Account) CloseAccount(email string, account_name string, closingTransaction bool) (Account, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Account{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1 || '/' || $2))`, email, account_name)
+	if err != nil {
+		return Account{}, err
+	}
+
+	var balance float32
+	query := `SELECT COALESCE(SUM(TransactionAmount), 0) FROM mrkrabs.Transactions
+	WHERE Username = $1 AND accountname = $2`
+	if err := tx.QueryRowContext(ctx, query, email, account_name).Scan(&balance); err != nil {
+		return Account{}, err
+	}
+
+	if balance != 0 {
+		if !closingTransaction {
+			return Account{}, fmt.Errorf("account balance is %.2f, only accounts with a zero balance can be closed", balance)
+		}
+		// postTransaction takes the same lock, which is reentrant within tx
+		_, _, err := postTransaction(ctx, tx, pendingTransaction{
+			Username: email,
+			Account:  account_name,
+			Amount:   -balance,
+			Name:     "closing balance",
+			Category: CategoryClosing,
+		})
+		if err != nil {
+			return Account{}, err
+		}
+	}
+
+	query = `UPDATE mrkrabs.Account
+	SET status = $1, statuschangedat = now()
+	WHERE username = $2 AND accountname = $3 AND status = $4
+	RETURNING ` + accountColumns
+
+	account, err := scanAccount(tx.QueryRowContext(ctx, query, AccountStatusClosed, email, account_name, AccountStatusOpen))
+	if errors.Is(err, sql.ErrNoRows) {
+		return account, errors.New("only open accounts can be closed")
+	}
+	if err != nil {
+		return account, err
+	}
+	return account, tx.Commit()
+}
+
+// RestoreAccount reopens an archived or closed account within the grace
+// period.
+func (t *Account) RestoreAccount(email string, account_name string) (Account, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	query := `UPDATE mrkrabs.Account
+	SET status = $1, statuschangedat = now()
+	WHERE username = $2 AND accountname = $3 AND status <> $1 AND statuschangedat > $4
+	RETURNING ` + accountColumns
+
+	account, err := scanAccount(db.QueryRowContext(ctx, query, AccountStatusOpen, email, account_name, time.Now().Add(-AccountGracePeriod)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return account, errors.New("account is not archived or closed, or its grace period has passed")
+	}
+	return account, err
+}
@@ -0,0 +1,132 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+const (
+	AccountTypeChequing     = "chequing"
+	AccountTypeSavings      = "savings"
+	AccountTypeCreditCard   = "credit_card"
+	AccountTypeLineOfCredit = "line_of_credit"
+	AccountTypeCash         = "cash"
+	AccountTypeLoan         = "loan"
+)
+
+var accountTypes = map[string]bool{
+	AccountTypeChequing:     true,
+	AccountTypeSavings:      true,
+	AccountTypeCreditCard:   true,
+	AccountTypeLineOfCredit: true,
+	AccountTypeCash:         true,
+	AccountTypeLoan:         true,
+}
+
+func validateAccountType(accountType string, creditLimit float32, monthlyWithdrawalLimit int) error {
+	if !accountTypes[accountType] {
+		return fmt.Errorf("unknown account type %q", accountType)
+	}
+	if creditLimit < 0 {
+		return errors.New("credit limit can not be negative")
+	}
+	if monthlyWithdrawalLimit < 0 {
+		return errors.New("monthly withdrawal limit can not be negative")
+	}
+	return nil
+}
+
+// IsCredit reports whether the account is a credit product whose balance may
+// go below zero.
+func (t *Account) IsCredit() bool {
+	return t.AccountType == AccountTypeCreditCard || t.AccountType == AccountTypeLineOfCredit || t.AccountType == AccountTypeLoan
+}
+
+// SkipsReconciliation reports whether the account is left out of
+// reconciliation against bank statements.
+func (t *Account) SkipsReconciliation() bool {
+	return t.AccountType == AccountTypeCash
+}
+
+// typeMinimum is the lowest balance the account type allows. Credit cards
+// and lines of credit may go down to their credit limit. Loans have no floor
+// unless a limit is set.
+func (t *Account) typeMinimum() (float32, bool) {
+	switch {
+	case t.AccountType == AccountTypeLoan && t.CreditLimit == 0:
+		return 0, false
+	case t.IsCredit():
+		return -t.CreditLimit, true
+	}
+	return 0, true
+}
+
+const accountColumns = `accountid, accountname, username, isprimary, accounttype, creditlimit, monthlywithdrawallimit,
+	minimumbalance, overdraftlimit, overdraftfee, recurringmayoverdraw,
+	status, statuschangedat, previousname, renamedat, envelopemode`
+
+func scanAccount(row interface{ Scan(...any) error }) (Account, error) {
+	var account Account
+	err := row.Scan(&account.AccountID, &account.AccountName, &account.Email, &account.IsPrimary, &account.AccountType, &account.CreditLimit, &account.MonthlyWithdrawalLimit,
+		&account.Policy.MinimumBalance, &account.Policy.OverdraftLimit, &account.Policy.OverdraftFee, &account.Policy.RecurringMayOverdraw,
+		&account.Status, &account.StatusChangedAt, &account.PreviousName, &account.RenamedAt, &account.EnvelopeMode)
+	return account, err
+}
+
+func (t *Account) GetAccount(email string, account_name string) (Account, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	query := `SELECT ` + accountColumns + `
+				FROM mrkrabs.Account WHERE username = $1 AND accountname = $2`
+
+	return scanAccount(db.QueryRowContext(ctx, query, email, account_name))
+}
+
+// accountOrDefault returns the account, or a plain chequing account when
+// transactions are posted to an account name that was never added.
+func accountOrDefault(ctx context.Context, tx *sql.Tx, email string, account_name string) (Account, error) {
+	query := `SELECT ` + accountColumns + `
+				FROM mrkrabs.Account WHERE username = $1 AND accountname = $2`
+
+	account, err := scanAccount(tx.QueryRowContext(ctx, query, email, account_name))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Account{AccountName: account_name, Email: email, AccountType: AccountTypeChequing}, nil
+	}
+	return account, err
+}
+
+func (t *Account) UpdateAccountType(email string, account_name string, accountType string, creditLimit float32, monthlyWithdrawalLimit int) (Account, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if err := validateAccountType(accountType, creditLimit, monthlyWithdrawalLimit); err != nil {
+		return Account{}, err
+	}
+	if err := checkWritable(ctx, db, email, account_name); err != nil {
+		return Account{}, err
+	}
+
+	query := `UPDATE mrkrabs.Account
+	SET accounttype = $1, creditlimit = $2, monthlywithdrawallimit = $3
+	WHERE username = $4 AND accountname = $5
+	RETURNING ` + accountColumns
+
+	return scanAccount(db.QueryRowContext(ctx, query, accountType, creditLimit, monthlyWithdrawalLimit, email, account_name))
+}
+
+// withdrawalsThisMonth counts the withdrawals made from the account since the
+// start of the current month.
+func (t *Account) withdrawalsThisMonth(ctx context.Context, tx *sql.Tx) (int, error) {
+	query := `SELECT COUNT(*) FROM mrkrabs.Transactions
+	WHERE username = $1 AND accountname = $2 AND transactionamount < 0
+		AND transactiondate >= date_trunc('month', now())`
+
+	var n int
+	err := tx.QueryRowContext(ctx, query, t.Email, t.AccountName).Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,194 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	CashFlowWeek    = "week"
+	CashFlowMonth   = "month"
+	CashFlowQuarter = "quarter"
+)
+
+// maxCashFlowPeriods bounds the number of periods in a cash flow report.
+const maxCashFlowPeriods = 520
+
+// CashFlowPeriod is the money that came into and went out of an account
+// during one week, month or quarter. Expenses are given as positive amounts.
+type CashFlowPeriod struct {
+	Period           string             `json:"period"`
+	Income           float32            `json:"income"`
+	Expenses         float32            `json:"expenses"`
+	Net              float32            `json:"net"`
+	PeriodOverPeriod CashFlowDelta      `json:"periodOverPeriod"`
+	YearOverYear     CashFlowDelta      `json:"yearOverYear"`
+	Categories       []CashFlowCategory `json:"categories"`
+}
+
+// CashFlowCategory is the part of a period in one category.
+type CashFlowCategory struct {
+	Category         string        `json:"category"`
+	Income           float32       `json:"income"`
+	Expenses         float32       `json:"expenses"`
+	Net              float32       `json:"net"`
+	PeriodOverPeriod CashFlowDelta `json:"periodOverPeriod"`
+	YearOverYear     CashFlowDelta `json:"yearOverYear"`
+}
+
+// CashFlowDelta compares a net cash flow with an earlier one. Percent is left
+// out when the earlier one is zero.
+type CashFlowDelta struct {
+	Previous float32  `json:"previous"`
+	Change   float32  `json:"change"`
+	Percent  *float32 `json:"percent,omitempty"`
+}
+
+func newCashFlowDelta(current float32, previous float32) CashFlowDelta {
+	d := CashFlowDelta{Previous: previous, Change: current - previous}
+	if previous != 0 {
+		pct := d.Change / abs32(previous) * 100
+		d.Percent = &pct
+	}
+	return d
+}
+
+func abs32(f float32) float32 {
+	if f < 0 {
+		return -f
+	}
+	return f
+}
+
+// cashFlowInterval describes how the periods of an interval are laid out: how
+// to find the start of the period holding a day, and the steps from the start
+// of a period to the next, the previous and the same period a year before.
+type cashFlowInterval struct {
+	start    func(t time.Time) time.Time
+	next     func(t time.Time) time.Time
+	previous func(t time.Time) time.Time
+	yearAgo  func(t time.Time) time.Time
+}
+
+var cashFlowIntervals = map[string]cashFlowInterval{
+	// weeks start on Monday, like date_trunc('week', ...)
+	CashFlowWeek: {
+		start: func(t time.Time) time.Time {
+			d := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+			return d.AddDate(0, 0, -((int(d.Weekday()) + 6) % 7))
+		},
+		next:     func(t time.Time) time.Time { return t.AddDate(0, 0, 7) },
+		previous: func(t time.Time) time.Time { return t.AddDate(0, 0, -7) },
+		yearAgo:  func(t time.Time) time.Time { return t.AddDate(0, 0, -52*7) },
+	},
+	CashFlowMonth: {
+		start: func(t time.Time) time.Time {
+			return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		},
+		next:     func(t time.Time) time.Time { return t.AddDate(0, 1, 0) },
+		previous: func(t time.Time) time.Time { return t.AddDate(0, -1, 0) },
+		yearAgo:  func(t time.Time) time.Time { return t.AddDate(-1, 0, 0) },
+	},
+	CashFlowQuarter: {
+		start: func(t time.Time) time.Time {
+			return time.Date(t.Year(), t.Month()-(t.Month()-1)%3, 1, 0, 0, 0, 0, time.UTC)
+		},
+		next:     func(t time.Time) time.Time { return t.AddDate(0, 3, 0) },
+		previous: func(t time.Time) time.Time { return t.AddDate(0, -3, 0) },
+		yearAgo:  func(t time.Time) time.Time { return t.AddDate(-1, 0, 0) },
+	},
+}
+
+// GetCashFlow returns the cash flow of an account for every week, month or
+// quarter overlapping the days from up to, but not including, to. Every
+// period and each of its categories is compared with the period before and
+// with the same period a year earlier. Split transactions count in the
+// categories of their lines.
+func (t *Transaction) GetCashFlow(username string, account string, interval string, from time.Time, to time.Time) ([]CashFlowPeriod, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	iv, ok := cashFlowIntervals[interval]
+	if !ok {
+		return nil, fmt.Errorf("unknown interval %q, use week, month or quarter", interval)
+	}
+	if !from.Before(to) {
+		return nil, errors.New("cash flow range must end after it starts")
+	}
+
+	var starts []time.Time
+	for p := iv.start(from); p.Before(to); p = iv.next(p) {
+		starts = append(starts, p)
+		if len(starts) > maxCashFlowPeriods {
+			return nil, fmt.Errorf("cash flow range can hold at most %d periods", maxCashFlowPeriods)
+		}
+	}
+
+	// reach back far enough to compare the first period with a year before
+	lookback := iv.yearAgo(starts[0])
+
+	query := `SELECT to_char(date_trunc($3, transactiondate), 'YYYY-MM-DD'), category,
+		COALESCE(SUM(transactionamount) FILTER (WHERE transactionamount > 0), 0),
+		COALESCE(-SUM(transactionamount) FILTER (WHERE transactionamount < 0), 0)
+	FROM ` + categoryLines + ` t
+	WHERE username = $1 AND accountname = $2 AND transactiondate >= $4 AND transactiondate < $5
+	GROUP BY 1, 2`
+
+	rows, err := db.QueryContext(ctx, query, username, account, interval, lookback, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// per period start, per category
+	flows := map[string]map[string]*CashFlowCategory{}
+	for rows.Next() {
+		var period string
+		c := &CashFlowCategory{}
+		if err := rows.Scan(&period, &c.Category, &c.Income, &c.Expenses); err != nil {
+			return nil, err
+		}
+		c.Net = c.Income - c.Expenses
+		if flows[period] == nil {
+			flows[period] = map[string]*CashFlowCategory{}
+		}
+		flows[period][c.Category] = c
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	net := func(p time.Time, category *string) float32 {
+		var n float32
+		for name, c := range flows[p.Format("2006-01-02")] {
+			if category == nil || *category == name {
+				n += c.Net
+			}
+		}
+		return n
+	}
+
+	periods := []CashFlowPeriod{}
+	for _, start := range starts {
+		p := CashFlowPeriod{Period: start.Format("2006-01-02"), Categories: []CashFlowCategory{}}
+		for _, c := range flows[p.Period] {
+			name := c.Category
+			c.PeriodOverPeriod = newCashFlowDelta(c.Net, net(iv.previous(start), &name))
+			c.YearOverYear = newCashFlowDelta(c.Net, net(iv.yearAgo(start), &name))
+			p.Categories = append(p.Categories, *c)
+			p.Income += c.Income
+			p.Expenses += c.Expenses
+		}
+		sort.Slice(p.Categories, func(i, j int) bool {
+			return p.Categories[i].Category < p.Categories[j].Category
+		})
+		p.Net = p.Income - p.Expenses
+		p.PeriodOverPeriod = newCashFlowDelta(p.Net, net(iv.previous(start), nil))
+		p.YearOverYear = newCashFlowDelta(p.Net, net(iv.yearAgo(start), nil))
+		periods = append(periods, p)
+	}
+	return periods, nil
+}
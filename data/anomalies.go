@@ -0,0 +1,181 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	FlagLargeAmount       = "large_amount"
+	FlagNewPayee          = "new_payee"
+	FlagPossibleDuplicate = "possible_duplicate"
+)
+
+const (
+	// anomalyMinHistory is how many earlier withdrawals a category needs
+	// before its typical amount is trusted.
+	anomalyMinHistory = 5
+	// anomalyLargeFactor is how many times the typical amount of its category
+	// a withdrawal must be to be flagged as large.
+	anomalyLargeFactor = 3
+	// duplicateWindow is how close together two identical withdrawals must be
+	// to look like a duplicate charge.
+	duplicateWindow = 10 * time.Minute
+)
+
+// TransactionFlag marks a transaction as unusual for its account.
+type TransactionFlag struct {
+	Flag       string     `json:"flag"`
+	Detail     string     `json:"detail"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ReviewedAt *time.Time `json:"reviewedAt,omitempty"`
+}
+
+// FlaggedTransaction is a transaction waiting in the review queue.
+type FlaggedTransaction struct {
+	Transaction Transaction       `json:"transaction"`
+	Flags       []TransactionFlag `json:"flags"`
+}
+
+// transactionFlagsColumn selects the flags of the transaction t that have not
+// been reviewed yet.
+const transactionFlagsColumn = `COALESCE((SELECT array_agg(f.flag ORDER BY f.flag) FROM mrkrabs.TransactionFlag f
+		WHERE f.transactionid = t.TransactionID AND f.reviewedat IS NULL), '{}')`
+
+// flagTransaction checks a withdrawal just posted to an account against the
+// earlier transactions of the account and records what looks unusual about
+// it. Deposits are never flagged.
+func flagTransaction(ctx context.Context, tx *sql.Tx, p pendingTransaction, transactionID int) error {
+	if p.Amount >= 0 {
+		return nil
+	}
+
+	var flags []TransactionFlag
+	spent := -p.Amount
+
+	if p.Category != "" {
+		var typical sql.NullFloat64
+		var n int
+		query := `SELECT percentile_cont(0.5) WITHIN GROUP (ORDER BY -transactionamount), COUNT(*)
+		FROM mrkrabs.Transactions
+		WHERE username = $1 AND accountname = $2 AND category = $3 AND transactionamount < 0 AND transactionid < $4`
+		if err := tx.QueryRowContext(ctx, query, p.Username, p.Account, p.Category, transactionID).Scan(&typical, &n); err != nil {
+			return err
+		}
+		if n >= anomalyMinHistory && typical.Valid && float64(spent) > anomalyLargeFactor*typical.Float64 {
+			flags = append(flags, TransactionFlag{
+				Flag:   FlagLargeAmount,
+				Detail: fmt.Sprintf("%.2f is more than %d times the typical %.2f spent on %s", spent, anomalyLargeFactor, typical.Float64, p.Category),
+			})
+		}
+	}
+
+	// only transactions posted before this one count, which leaves out the
+	// overdraft fee it may just have been charged
+	var seen, history bool
+	query := `SELECT
+		EXISTS (SELECT 1 FROM mrkrabs.Transactions WHERE username = $1 AND accountname = $2 AND transactionid < $4
+			AND lower(transactionname) = lower($3)),
+		EXISTS (SELECT 1 FROM mrkrabs.Transactions WHERE username = $1 AND accountname = $2 AND transactionid < $4)`
+	if err := tx.QueryRowContext(ctx, query, p.Username, p.Account, p.Name, transactionID).Scan(&seen, &history); err != nil {
+		return err
+	}
+	// on a new account every payee would be new
+	if history && !seen && p.Name != "" {
+		flags = append(flags, TransactionFlag{
+			Flag:   FlagNewPayee,
+			Detail: fmt.Sprintf("first transaction with %s", p.Name),
+		})
+	}
+
+	var duplicateOf sql.NullInt64
+	query = `SELECT MAX(transactionid) FROM mrkrabs.Transactions
+	WHERE username = $1 AND accountname = $2 AND transactionid < $3
+		AND transactionamount = $4 AND lower(transactionname) = lower($5) AND transactiondate > $6`
+	err := tx.QueryRowContext(ctx, query, p.Username, p.Account, transactionID, p.Amount, p.Name, time.Now().Add(-duplicateWindow)).Scan(&duplicateOf)
+	if err != nil {
+		return err
+	}
+	if duplicateOf.Valid {
+		flags = append(flags, TransactionFlag{
+			Flag:   FlagPossibleDuplicate,
+			Detail: fmt.Sprintf("same amount and payee as transaction %d a few minutes earlier", duplicateOf.Int64),
+		})
+	}
+
+	insert := `INSERT INTO mrkrabs.TransactionFlag (transactionid, flag, detail) VALUES ($1, $2, $3)
+	ON CONFLICT DO NOTHING`
+	for _, f := range flags {
+		if _, err := tx.ExecContext(ctx, insert, transactionID, f.Flag, f.Detail); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetReviewQueue returns the transactions of an account with flags that have
+// not been reviewed, newest first.
+func (t *Transaction) GetReviewQueue(username string, account string) ([]FlaggedTransaction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	query := `SELECT t.TransactionID, t.username, t.transactionamount, t.transactionname, t.transactiondescription, t.category, t.transactiondate,
+		f.flag, f.detail, f.createdat
+	FROM mrkrabs.Transactions t
+	JOIN mrkrabs.TransactionFlag f ON f.transactionid = t.TransactionID
+	WHERE t.username = $1 AND t.accountname = $2 AND f.reviewedat IS NULL
+	ORDER BY t.transactiondate DESC, t.TransactionID DESC, f.flag`
+
+	rows, err := db.QueryContext(ctx, query, username, account)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	queue := []FlaggedTransaction{}
+	for rows.Next() {
+		var trans Transaction
+		var f TransactionFlag
+		if err := rows.Scan(&trans.TransactionID, &trans.UserID, &trans.TransactionAmount, &trans.TransactionName, &trans.TransactionDescription, &trans.TransactionCategory, &trans.TransactionDate,
+			&f.Flag, &f.Detail, &f.CreatedAt); err != nil {
+			return queue, err
+		}
+		if n := len(queue); n > 0 && queue[n-1].Transaction.TransactionID == trans.TransactionID {
+			queue[n-1].Flags = append(queue[n-1].Flags, f)
+			queue[n-1].Transaction.Flags = append(queue[n-1].Transaction.Flags, f.Flag)
+			continue
+		}
+		trans.Flags = []string{f.Flag}
+		queue = append(queue, FlaggedTransaction{Transaction: trans, Flags: []TransactionFlag{f}})
+	}
+	if err = rows.Err(); err != nil {
+		return queue, err
+	}
+	return queue, nil
+}
+
+// ClearReview marks the flags of a transaction as reviewed, or those of every
+// transaction of the account when transactionID is nil. It returns the number
+// of flags cleared.
+func (t *Transaction) ClearReview(username string, account string, transactionID *int) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	query := `UPDATE mrkrabs.TransactionFlag f SET reviewedat = now()
+	FROM mrkrabs.Transactions t
+	WHERE f.transactionid = t.TransactionID AND t.username = $1 AND t.accountname = $2
+		AND f.reviewedat IS NULL AND ($3::int IS NULL OR t.TransactionID = $3)`
+
+	res, err := db.ExecContext(ctx, query, username, account, transactionID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 && transactionID != nil {
+		return 0, fmt.Errorf("transaction %d has no flags to clear", *transactionID)
+	}
+	return n, nil
+}
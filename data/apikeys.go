@@ -0,0 +1,205 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgtype"
+)
+
+// APIKeyPrefix starts every generated key so the auth layer can tell keys
+// apart from JWTs.
+const APIKeyPrefix = "mk_"
+
+const (
+	ScopeReadBalance      = "balance:read"
+	ScopePostTransactions = "transactions:write"
+	ScopeManageRecurring  = "recurring:manage"
+)
+
+var validScopes = map[string]bool{
+	ScopeReadBalance:      true,
+	ScopePostTransactions: true,
+	ScopeManageRecurring:  true,
+}
+
+var ErrInvalidAPIKey = errors.New("invalid, expired or revoked api key")
+
+type APIKey struct {
+	KeyID      int        `json:"keyID"`
+	UserID     string     `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Accounts   []string   `json:"accounts"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// HasScope reports whether the key was granted scope.
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAccount reports whether the key may act on account.
+func (k *APIKey) HasAccount(account string) bool {
+	for _, a := range k.Accounts {
+		if a == account {
+			return true
+		}
+	}
+	return false
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func scanAPIKey(row interface{ Scan(...any) error }) (APIKey, error) {
+	var k APIKey
+	var accounts, scopes pgtype.TextArray
+
+	err := row.Scan(&k.KeyID, &k.UserID, &k.Name, &k.Prefix, &accounts, &scopes, &k.ExpiresAt, &k.LastUsedAt, &k.RevokedAt, &k.CreatedAt)
+	if err != nil {
+		return k, err
+	}
+	if err := accounts.AssignTo(&k.Accounts); err != nil {
+		return k, err
+	}
+	if err := scopes.AssignTo(&k.Scopes); err != nil {
+		return k, err
+	}
+	return k, nil
+}
+
+// CreateAPIKey stores a new key for userID and returns it along with the
+// plain text key. The plain text is never stored and can not be recovered.
+func (k *APIKey) CreateAPIKey(userID string, name string, accounts []string, scopes []string, expiresAt *time.Time) (APIKey, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if len(accounts) == 0 {
+		return APIKey{}, "", errors.New("an api key must be limited to at least one account")
+	}
+	if len(scopes) == 0 {
+		return APIKey{}, "", errors.New("an api key must have at least one scope")
+	}
+	for _, s := range scopes {
+		if !validScopes[s] {
+			return APIKey{}, "", fmt.Errorf("unknown scope %q", s)
+		}
+	}
+	if expiresAt != nil && expiresAt.Before(time.Now()) {
+		return APIKey{}, "", errors.New("expiry must be in the future")
+	}
+
+	var a Account
+	for _, account := range accounts {
+		member, err := a.IsMember(userID, account)
+		if err != nil {
+			return APIKey{}, "", err
+		}
+		if !member {
+			return APIKey{}, "", fmt.Errorf("user is not a member of account %s", account)
+		}
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return APIKey{}, "", err
+	}
+	prefix := hex.EncodeToString(buf[:4])
+	plain := fmt.Sprintf("%s%s_%s", APIKeyPrefix, prefix, base64.RawURLEncoding.EncodeToString(buf[4:]))
+
+	query := `INSERT INTO mrkrabs.ApiKey (UserID, Name, Prefix, KeyHash, Accounts, Scopes, ExpiresAt)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING KeyID, UserID, Name, Prefix, Accounts, Scopes, ExpiresAt, LastUsedAt, RevokedAt, CreatedAt`
+
+	row := db.QueryRowContext(ctx, query, userID, name, prefix, hashAPIKey(plain), accounts, scopes, expiresAt)
+	key, err := scanAPIKey(row)
+	if err != nil {
+		return key, "", err
+	}
+	return key, plain, nil
+}
+
+// Authenticate looks up a live key by its plain text and records its use.
+func (k *APIKey) Authenticate(plain string) (APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	query := `UPDATE mrkrabs.ApiKey SET LastUsedAt = now()
+	WHERE KeyHash = $1
+		AND RevokedAt IS NULL
+		AND (ExpiresAt IS NULL OR ExpiresAt > now())
+	RETURNING KeyID, UserID, Name, Prefix, Accounts, Scopes, ExpiresAt, LastUsedAt, RevokedAt, CreatedAt`
+
+	key, err := scanAPIKey(db.QueryRowContext(ctx, query, hashAPIKey(plain)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return key, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return key, err
+	}
+	return key, nil
+}
+
+func (k *APIKey) GetAPIKeys(userID string) ([]APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	query := `SELECT KeyID, UserID, Name, Prefix, Accounts, Scopes, ExpiresAt, LastUsedAt, RevokedAt, CreatedAt
+	FROM mrkrabs.ApiKey WHERE UserID = $1 ORDER BY CreatedAt`
+
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return keys, err
+		}
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		return keys, err
+	}
+	return keys, nil
+}
+
+func (k *APIKey) RevokeAPIKey(userID string, keyID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	query := `UPDATE mrkrabs.ApiKey SET RevokedAt = now()
+	WHERE KeyID = $1 AND UserID = $2 AND RevokedAt IS NULL`
+
+	res, err := db.ExecContext(ctx, query, keyID, userID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("api key not found or already revoked")
+	}
+	return nil
+}
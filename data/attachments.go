@@ -0,0 +1,252 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// MaxAttachmentSize bounds the size of an attachment.
+const MaxAttachmentSize = 10 << 20
+
+var (
+	ErrAttachmentTooLarge = fmt.Errorf("attachments can be at most %d MB", MaxAttachmentSize>>20)
+	ErrAttachmentType     = errors.New("attachments can only be JPEG, PNG, GIF or WebP images or PDF documents")
+)
+
+// attachmentTypes lists the content types an attachment can have, as
+// detected from its content.
+var attachmentTypes = []string{
+	"image/jpeg",
+	"image/png",
+	"image/gif",
+	"image/webp",
+	"application/pdf",
+}
+
+// Attachment is a receipt or document kept with a transaction or a debt.
+// Its content sits in the blob store under StorageKey.
+type Attachment struct {
+	AttachmentID  int        `json:"attachmentID"`
+	AccountName   string     `json:"accountName"`
+	TransactionID *int       `json:"transactionID,omitempty"`
+	DebtID        *int       `json:"debtID,omitempty"`
+	FileName      string     `json:"fileName"`
+	ContentType   string     `json:"contentType"`
+	Size          int64      `json:"size"`
+	SHA256        string     `json:"sha256"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	StorageKey    string     `json:"-"`
+	URL           string     `json:"url,omitempty"`
+	URLExpiresAt  *time.Time `json:"urlExpiresAt,omitempty"`
+}
+
+const attachmentColumns = `attachmentid, accountname, transactionid, debtid, filename, contenttype, size, sha256, createdat, storagekey`
+
+func scanAttachment(row interface{ Scan(...any) error }) (Attachment, error) {
+	var a Attachment
+	var transactionID, debtID sql.NullInt64
+	err := row.Scan(&a.AttachmentID, &a.AccountName, &transactionID, &debtID, &a.FileName, &a.ContentType,
+		&a.Size, &a.SHA256, &a.CreatedAt, &a.StorageKey)
+	if transactionID.Valid {
+		id := int(transactionID.Int64)
+		a.TransactionID = &id
+	}
+	if debtID.Valid {
+		id := int(debtID.Int64)
+		a.DebtID = &id
+	}
+	return a, err
+}
+
+// cleanFileName keeps the base name of an uploaded file.
+func cleanFileName(name string) string {
+	name = strings.TrimSpace(path.Base(strings.ReplaceAll(name, "\\", "/")))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "" || name == "." || name == "/" {
+		return "attachment"
+	}
+	if len(name) > 255 {
+		name = name[len(name)-255:]
+	}
+	return name
+}
+
+// CreateAttachment stores a file and attaches it to either a transaction or a
+// debt of an account. The type of the file is detected from its content.
+func (a *Attachment) CreateAttachment(username string, account string, transactionID *int, debtID *int, fileName string, content []byte) (Attachment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout*10)
+	defer cancel()
+
+	if (transactionID == nil) == (debtID == nil) {
+		return Attachment{}, errors.New("an attachment belongs to either a transaction or a debt")
+	}
+	if len(content) == 0 {
+		return Attachment{}, errors.New("attachments can not be empty")
+	}
+	if len(content) > MaxAttachmentSize {
+		return Attachment{}, ErrAttachmentTooLarge
+	}
+	contentType := http.DetectContentType(content)
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	if !containsString(attachmentTypes, contentType) {
+		return Attachment{}, ErrAttachmentType
+	}
+	if blobs == nil {
+		return Attachment{}, errors.New("no attachment storage configured")
+	}
+
+	if err := checkWritable(ctx, db, username, account); err != nil {
+		return Attachment{}, err
+	}
+
+	var exists bool
+	if transactionID != nil {
+		query := `SELECT EXISTS (SELECT 1 FROM mrkrabs.Transactions WHERE transactionid = $1 AND username = $2 AND accountname = $3)`
+		if err := db.QueryRowContext(ctx, query, *transactionID, username, account).Scan(&exists); err != nil {
+			return Attachment{}, err
+		}
+		if !exists {
+			return Attachment{}, fmt.Errorf("transaction %d not found", *transactionID)
+		}
+	} else {
+		query := `SELECT EXISTS (SELECT 1 FROM mrkrabs.Debt WHERE debtid = $1 AND userid = $2 AND accountname = $3)`
+		if err := db.QueryRowContext(ctx, query, *debtID, username, account).Scan(&exists); err != nil {
+			return Attachment{}, err
+		}
+		if !exists {
+			return Attachment{}, fmt.Errorf("debt %d not found", *debtID)
+		}
+	}
+
+	sum := sha256.Sum256(content)
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return Attachment{}, err
+	}
+	key := "attachments/" + hex.EncodeToString(buf)
+
+	if err := blobs.Put(ctx, key, content, contentType); err != nil {
+		return Attachment{}, err
+	}
+
+	query := `INSERT INTO mrkrabs.Attachment (username, accountname, transactionid, debtid, filename, contenttype, size, sha256, storagekey, createdat)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, now())
+	RETURNING ` + attachmentColumns
+	row := db.QueryRowContext(ctx, query, username, account, transactionID, debtID, cleanFileName(fileName),
+		contentType, len(content), hex.EncodeToString(sum[:]), key)
+	attachment, err := scanAttachment(row)
+	if err != nil {
+		blobs.Delete(ctx, key)
+		return Attachment{}, err
+	}
+	return attachment, nil
+}
+
+// GetAttachments returns the attachments of a transaction or, with a nil
+// transactionID, of a debt.
+func (a *Attachment) GetAttachments(username string, account string, transactionID *int, debtID *int) ([]Attachment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	query := `SELECT ` + attachmentColumns + `
+	FROM mrkrabs.Attachment
+	WHERE username = $1 AND accountname = $2
+		AND transactionid IS NOT DISTINCT FROM $3 AND debtid IS NOT DISTINCT FROM $4
+	ORDER BY createdat, attachmentid`
+
+	rows, err := db.QueryContext(ctx, query, username, account, transactionID, debtID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := []Attachment{}
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return attachments, err
+		}
+		attachments = append(attachments, attachment)
+	}
+	if err = rows.Err(); err != nil {
+		return attachments, err
+	}
+	return attachments, nil
+}
+
+func (a *Attachment) GetAttachment(username string, account string, attachmentID int) (Attachment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	query := `SELECT ` + attachmentColumns + `
+	FROM mrkrabs.Attachment
+	WHERE attachmentid = $1 AND username = $2 AND accountname = $3`
+
+	attachment, err := scanAttachment(db.QueryRowContext(ctx, query, attachmentID, username, account))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Attachment{}, fmt.Errorf("attachment %d not found", attachmentID)
+	}
+	return attachment, err
+}
+
+// GetAttachmentByID returns an attachment of any user. It backs signed
+// download links, which are checked before.
+func (a *Attachment) GetAttachmentByID(attachmentID int) (Attachment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	query := `SELECT ` + attachmentColumns + ` FROM mrkrabs.Attachment WHERE attachmentid = $1`
+
+	attachment, err := scanAttachment(db.QueryRowContext(ctx, query, attachmentID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Attachment{}, fmt.Errorf("attachment %d not found", attachmentID)
+	}
+	return attachment, err
+}
+
+// Open returns the content of an attachment. The caller closes it.
+func (a *Attachment) Open(ctx context.Context) (io.ReadCloser, error) {
+	if blobs == nil {
+		return nil, errors.New("no attachment storage configured")
+	}
+	return blobs.Get(ctx, a.StorageKey)
+}
+
+// DeleteAttachment removes an attachment and its content.
+func (a *Attachment) DeleteAttachment(username string, account string, attachmentID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout*10)
+	defer cancel()
+
+	if err := checkWritable(ctx, db, username, account); err != nil {
+		return err
+	}
+
+	var key string
+	query := `DELETE FROM mrkrabs.Attachment WHERE attachmentid = $1 AND username = $2 AND accountname = $3
+	RETURNING storagekey`
+	err := db.QueryRowContext(ctx, query, attachmentID, username, account).Scan(&key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("attachment %d not found", attachmentID)
+	}
+	if err != nil {
+		return err
+	}
+	if blobs == nil {
+		return nil
+	}
+	return blobs.Delete(ctx, key)
+}
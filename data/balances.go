@@ -0,0 +1,79 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// maxBalanceHistoryDays bounds the length of a daily balance series.
+const maxBalanceHistoryDays = 3660
+
+// DailyBalance is the balance of an account at the end of a day.
+type DailyBalance struct {
+	Date    string  `json:"date"`
+	Balance float32 `json:"balance"`
+}
+
+// GetBalanceAsOf returns the balance of an account from the transactions
+// dated before a point in time.
+func (t *Transaction) GetBalanceAsOf(email string, account string, before time.Time) (float32, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	query := `SELECT COALESCE(SUM(TransactionAmount), 0) FROM mrkrabs.Transactions
+	WHERE Username = $1 AND accountname = $2 AND transactiondate < $3`
+
+	var balance float32
+	err := db.QueryRowContext(ctx, query, email, account, before).Scan(&balance)
+	return balance, err
+}
+
+// GetDailyBalances returns the end of day balance of an account for every day
+// from the date of from up to, but not including, the date of to. Days
+// without transactions carry the balance of the day before.
+func (t *Transaction) GetDailyBalances(email string, account string, from time.Time, to time.Time) ([]DailyBalance, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if !from.Before(to) {
+		return nil, errors.New("balance history must end after it starts")
+	}
+	if to.Sub(from) > maxBalanceHistoryDays*24*time.Hour {
+		return nil, errors.New("balance history can span at most ten years")
+	}
+
+	// the balance before the range plus a running total of the daily sums
+	query := `WITH daily AS (
+		SELECT transactiondate::date AS day, SUM(transactionamount) AS amount
+		FROM mrkrabs.Transactions
+		WHERE username = $1 AND accountname = $2 AND transactiondate < $4::date
+		GROUP BY 1
+	), series AS (
+		SELECT d::date AS day, COALESCE(daily.amount, 0) AS amount
+		FROM generate_series($3::date, $4::date - 1, interval '1 day') d
+		LEFT JOIN daily ON daily.day = d::date
+	)
+	SELECT to_char(day, 'YYYY-MM-DD'),
+		(SELECT COALESCE(SUM(amount), 0) FROM daily WHERE day < $3::date) + SUM(amount) OVER (ORDER BY day)
+	FROM series
+	ORDER BY day`
+
+	rows, err := db.QueryContext(ctx, query, email, account, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var balances []DailyBalance
+	for rows.Next() {
+		var b DailyBalance
+		if err := rows.Scan(&b.Date, &b.Balance); err != nil {
+			return balances, err
+		}
+		balances = append(balances, b)
+	}
+	if err = rows.Err(); err != nil {
+		return balances, err
+	}
+	return balances, nil
+}
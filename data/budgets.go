@@ -0,0 +1,226 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Budget is the amount planned for a category in one month. With Rollover
+// set, whatever is left of it, or overspent, carries into the next month's
+// budget for the category.
+type Budget struct {
+	BudgetID    int     `json:"budgetID"`
+	Username    string  `json:"username"`
+	AccountName string  `json:"accountname"`
+	Category    string  `json:"category"`
+	Month       string  `json:"month"`
+	Amount      float32 `json:"amount"`
+	Rollover    bool    `json:"rollover"`
+}
+
+// BudgetLine compares a budget with what was spent in its category.
+type BudgetLine struct {
+	Category    string   `json:"category"`
+	Month       string   `json:"month"`
+	Budgeted    float32  `json:"budgeted"`
+	RolledOver  float32  `json:"rolledOver"`
+	Available   float32  `json:"available"`
+	Spent       float32  `json:"spent"`
+	Remaining   float32  `json:"remaining"`
+	PercentUsed *float32 `json:"percentUsed,omitempty"`
+}
+
+const budgetColumns = `budgetid, username, accountname, category, to_char(month, 'YYYY-MM'), amount, rollover`
+
+func scanBudget(row interface{ Scan(...any) error }) (Budget, error) {
+	var b Budget
+	err := row.Scan(&b.BudgetID, &b.Username, &b.AccountName, &b.Category, &b.Month, &b.Amount, &b.Rollover)
+	return b, err
+}
+
+// ParseMonth parses a month formatted as 2006-01.
+func ParseMonth(month string) (time.Time, error) {
+	t, err := time.Parse("2006-01", month)
+	if err != nil {
+		return t, fmt.Errorf("month must look like 2006-01: %w", err)
+	}
+	return t, nil
+}
+
+// moveBudgets moves the budgets of one category onto another, adding them up
+// for months where both have a budget.
+func moveBudgets(ctx context.Context, tx *sql.Tx, username string, account string, from string, to string) error {
+	query := `INSERT INTO mrkrabs.Budget (username, accountname, category, month, amount, rollover)
+	SELECT username, accountname, $4, month, amount, rollover FROM mrkrabs.Budget
+	WHERE username = $1 AND accountname = $2 AND category = $3
+	ON CONFLICT (username, accountname, category, month)
+	DO UPDATE SET amount = mrkrabs.Budget.amount + EXCLUDED.amount`
+	if _, err := tx.ExecContext(ctx, query, username, account, from, to); err != nil {
+		return err
+	}
+	return deleteBudgets(ctx, tx, username, account, from)
+}
+
+func deleteBudgets(ctx context.Context, tx *sql.Tx, username string, account string, category string) error {
+	query := `DELETE FROM mrkrabs.Budget WHERE username = $1 AND accountname = $2 AND category = $3`
+	_, err := tx.ExecContext(ctx, query, username, account, category)
+	return err
+}
+
+func (b *Budget) GetBudgets(username string, account string, month string) ([]Budget, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	m, err := ParseMonth(month)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + budgetColumns + ` FROM mrkrabs.Budget
+	WHERE username = $1 AND accountname = $2 AND month = $3
+	ORDER BY category`
+
+	rows, err := db.QueryContext(ctx, query, username, account, m)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var budgets []Budget
+	for rows.Next() {
+		budget, err := scanBudget(rows)
+		if err != nil {
+			return budgets, err
+		}
+		budgets = append(budgets, budget)
+	}
+	if err = rows.Err(); err != nil {
+		return budgets, err
+	}
+	return budgets, nil
+}
+
+// SetBudget creates or replaces the budget of a category for a month.
+func (b *Budget) SetBudget(username string, account string, category string, month string, amount float32, rollover bool) (Budget, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	m, err := ParseMonth(month)
+	if err != nil {
+		return Budget{}, err
+	}
+	if amount < 0 {
+		return Budget{}, errors.New("budget amount can not be negative")
+	}
+	if category == "" {
+		return Budget{}, errors.New("a budget needs a category")
+	}
+	if err := checkWritable(ctx, db, username, account); err != nil {
+		return Budget{}, err
+	}
+	if err := validateCategory(ctx, db, username, account, category); err != nil {
+		return Budget{}, err
+	}
+
+	query := `INSERT INTO mrkrabs.Budget (username, accountname, category, month, amount, rollover)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (username, accountname, category, month)
+	DO UPDATE SET amount = EXCLUDED.amount, rollover = EXCLUDED.rollover
+	RETURNING ` + budgetColumns
+
+	return scanBudget(db.QueryRowContext(ctx, query, username, account, category, m, amount, rollover))
+}
+
+func (b *Budget) DeleteBudget(username string, account string, budgetID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	query := `DELETE FROM mrkrabs.Budget WHERE budgetid = $1 AND username = $2 AND accountname = $3`
+
+	res, err := db.ExecContext(ctx, query, budgetID, username, account)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("budget %d not found", budgetID)
+	}
+	return nil
+}
+
+// GetBudgetReport compares every budget of a month with the spending in its
+// category. Spending is the net of the category's transactions, so refunds
+// reduce it. Amounts carried over from earlier months are followed back for
+// as long as each previous month has a budget with rollover set.
+func (b *Budget) GetBudgetReport(username string, account string, month string) ([]BudgetLine, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	m, err := ParseMonth(month)
+	if err != nil {
+		return nil, err
+	}
+
+	// every budget up to the month, with what was spent in its category and
+	// month, oldest first
+	query := `SELECT b.category, to_char(b.month, 'YYYY-MM'), b.amount, b.rollover,
+		COALESCE(-SUM(t.transactionamount), 0)
+	FROM mrkrabs.Budget b
+	LEFT JOIN ` + categoryLines + ` t
+		ON t.username = b.username AND t.accountname = b.accountname AND t.category = b.category
+		AND t.transactiondate >= b.month AND t.transactiondate < b.month + interval '1 month'
+	WHERE b.username = $1 AND b.accountname = $2 AND b.month <= $3
+	GROUP BY b.category, b.month, b.amount, b.rollover
+	ORDER BY b.category, b.month`
+
+	rows, err := db.QueryContext(ctx, query, username, account, m)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type previous struct {
+		month     time.Time
+		remaining float32
+		rollover  bool
+	}
+	last := map[string]previous{}
+
+	var report []BudgetLine
+	for rows.Next() {
+		var line BudgetLine
+		var rollover bool
+		if err := rows.Scan(&line.Category, &line.Month, &line.Budgeted, &rollover, &line.Spent); err != nil {
+			return nil, err
+		}
+		lineMonth, err := ParseMonth(line.Month)
+		if err != nil {
+			return nil, err
+		}
+
+		if p, ok := last[line.Category]; ok && p.rollover && p.month.AddDate(0, 1, 0).Equal(lineMonth) {
+			line.RolledOver = p.remaining
+		}
+		line.Available = line.Budgeted + line.RolledOver
+		line.Remaining = line.Available - line.Spent
+		last[line.Category] = previous{month: lineMonth, remaining: line.Remaining, rollover: rollover}
+
+		if !lineMonth.Equal(m) {
+			continue
+		}
+		if line.Available > 0 {
+			pct := line.Spent / line.Available * 100
+			line.PercentUsed = &pct
+		}
+		report = append(report, line)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
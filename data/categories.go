@@ -0,0 +1,477 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+var colourPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// ErrUnknownCategory is returned when a transaction is tagged with a category
+// that does not exist on its account.
+var ErrUnknownCategory = errors.New("category does not exist on this account")
+
+type execQuerier interface {
+	querier
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+const categoryColumns = `categoryid, name, username, accountname, colour, icon, parentid`
+
+func scanCategory(row interface{ Scan(...any) error }) (Category, error) {
+	var c Category
+	err := row.Scan(&c.CategoryID, &c.TransactionCategory, &c.Username, &c.AccountName, &c.Colour, &c.Icon, &c.ParentID)
+	return c, err
+}
+
+// checkParent makes sure parentID is a category of the same account and,
+// when categoryID is set, is not the category itself or one of its
+// descendants.
+func checkParent(ctx context.Context, q querier, username string, account string, categoryID int, parentID *int) error {
+	if parentID == nil {
+		return nil
+	}
+	if _, err := getCategoryByID(ctx, q, username, account, *parentID); err != nil {
+		return err
+	}
+	if categoryID == 0 {
+		return nil
+	}
+
+	query := `WITH RECURSIVE tree AS (
+		SELECT categoryid FROM mrkrabs.Category WHERE categoryid = $1
+		UNION ALL
+		SELECT c.categoryid FROM mrkrabs.Category c JOIN tree t ON c.parentid = t.categoryid
+	)
+	SELECT EXISTS (SELECT 1 FROM tree WHERE categoryid = $2)`
+
+	var cycle bool
+	if err := q.QueryRowContext(ctx, query, categoryID, *parentID).Scan(&cycle); err != nil {
+		return err
+	}
+	if cycle {
+		return errors.New("a category can not be moved under itself or one of its children")
+	}
+	return nil
+}
+
+func validateCategoryFields(name string, colour string, icon string) error {
+	if name == "" {
+		return errors.New("category name is required")
+	}
+	if colour != "" && !colourPattern.MatchString(colour) {
+		return errors.New("colour must look like #rrggbb")
+	}
+	if len(icon) > 64 {
+		return errors.New("icon can be at most 64 characters")
+	}
+	return nil
+}
+
+// Categories the service posts transactions in by itself.
+const (
+	CategoryDebt    = "Debt"
+	CategoryFees    = "Fees"
+	CategoryClosing = "Closing"
+)
+
+var systemCategories = map[string]bool{CategoryDebt: true, CategoryFees: true, CategoryClosing: true}
+
+// ensureCategory creates a category on the account unless it exists already,
+// so system categories show up in reports and can be picked again.
+func ensureCategory(ctx context.Context, q execQuerier, username string, account string, name string) error {
+	if err := seedCategories(ctx, q, username, account); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO mrkrabs.Category (username, accountname, name, colour, icon)
+	SELECT $1, $2, $3, '', ''
+	WHERE NOT EXISTS (SELECT 1 FROM mrkrabs.Category WHERE username = $1 AND accountname = $2 AND name = $3)`
+
+	_, err := q.ExecContext(ctx, query, username, account, name)
+	return err
+}
+
+// seedCategories fills the category table of an account from the free text
+// categories already on its transactions, the first time it is used.
+func seedCategories(ctx context.Context, q execQuerier, username string, account string) error {
+	query := `INSERT INTO mrkrabs.Category (username, accountname, name, colour, icon)
+	SELECT DISTINCT username, accountname, category, '', ''
+	FROM mrkrabs.Transactions
+	WHERE username = $1 AND accountname = $2 AND category <> ''
+		AND NOT EXISTS (SELECT 1 FROM mrkrabs.Category WHERE username = $1 AND accountname = $2)`
+
+	_, err := q.ExecContext(ctx, query, username, account)
+	return err
+}
+
+// validateCategory returns ErrUnknownCategory unless the category exists on
+// the account. An empty category leaves a transaction uncategorized.
+func validateCategory(ctx context.Context, q execQuerier, username string, account string, category string) error {
+	if category == "" {
+		return nil
+	}
+	if err := seedCategories(ctx, q, username, account); err != nil {
+		return err
+	}
+
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM mrkrabs.Category WHERE username = $1 AND accountname = $2 AND name = $3)`
+	if err := q.QueryRowContext(ctx, query, username, account, category).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrUnknownCategory, category)
+	}
+	return nil
+}
+
+func getCategoryByID(ctx context.Context, q querier, username string, account string, categoryID int) (Category, error) {
+	query := `SELECT ` + categoryColumns + ` FROM mrkrabs.Category
+	WHERE categoryid = $1 AND username = $2 AND accountname = $3`
+
+	c, err := scanCategory(q.QueryRowContext(ctx, query, categoryID, username, account))
+	if errors.Is(err, sql.ErrNoRows) {
+		return c, fmt.Errorf("category %d not found", categoryID)
+	}
+	return c, err
+}
+
+// checkCategoryName refuses giving a category the name of another category of
+// the account. Moving transactions onto an existing category is a merge.
+func checkCategoryName(ctx context.Context, q querier, username string, account string, categoryID int, name string) error {
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM mrkrabs.Category
+		WHERE username = $1 AND accountname = $2 AND name = $3 AND categoryid <> $4)`
+	if err := q.QueryRowContext(ctx, query, username, account, name, categoryID).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("category %s already exists", name)
+	}
+	return nil
+}
+
+// retagTransactions moves every transaction and split line of an account from
+// one category name to another, and points the rules, budgets, envelope
+// assignments, savings goals and payees of the old name at the new one. It
+// returns the number of transactions moved.
+func retagTransactions(ctx context.Context, tx *sql.Tx, username string, account string, from string, to string) (int64, error) {
+	query := `UPDATE mrkrabs.Rule SET setcategory = $1
+	WHERE username = $2 AND accountname = $3 AND setcategory = $4`
+	if _, err := tx.ExecContext(ctx, query, to, username, account, from); err != nil {
+		return 0, err
+	}
+	if err := moveBudgets(ctx, tx, username, account, from, to); err != nil {
+		return 0, err
+	}
+	for _, table := range []string{"mrkrabs.EnvelopeAssignment", "mrkrabs.SavingsGoal", "mrkrabs.Payee"} {
+		query = `UPDATE ` + table + ` SET category = $1
+		WHERE username = $2 AND accountname = $3 AND category = $4`
+		if _, err := tx.ExecContext(ctx, query, to, username, account, from); err != nil {
+			return 0, err
+		}
+	}
+
+	query = `UPDATE mrkrabs.TransactionSplit s SET category = $1
+	FROM mrkrabs.Transactions t
+	WHERE t.transactionid = s.transactionid AND t.username = $2 AND t.accountname = $3 AND s.category = $4`
+	if _, err := tx.ExecContext(ctx, query, to, username, account, from); err != nil {
+		return 0, err
+	}
+
+	query = `UPDATE mrkrabs.Transactions SET category = $1
+	WHERE username = $2 AND accountname = $3 AND category = $4`
+
+	res, err := tx.ExecContext(ctx, query, to, username, account, from)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (c *Category) GetCategories(username string, account string) ([]Category, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if err := seedCategories(ctx, db, username, account); err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + categoryColumns + ` FROM mrkrabs.Category
+	WHERE username = $1 AND accountname = $2 ORDER BY name`
+
+	rows, err := db.QueryContext(ctx, query, username, account)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []Category
+	for rows.Next() {
+		category, err := scanCategory(rows)
+		if err != nil {
+			return categories, err
+		}
+		categories = append(categories, category)
+	}
+	if err = rows.Err(); err != nil {
+		return categories, err
+	}
+	return categories, nil
+}
+
+func (c *Category) CreateCategory(username string, account string, name string, colour string, icon string, parentID *int) (Category, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if err := validateCategoryFields(name, colour, icon); err != nil {
+		return Category{}, err
+	}
+	if err := checkWritable(ctx, db, username, account); err != nil {
+		return Category{}, err
+	}
+	if err := seedCategories(ctx, db, username, account); err != nil {
+		return Category{}, err
+	}
+	if err := checkCategoryName(ctx, db, username, account, 0, name); err != nil {
+		return Category{}, err
+	}
+	if err := checkParent(ctx, db, username, account, 0, parentID); err != nil {
+		return Category{}, err
+	}
+
+	query := `INSERT INTO mrkrabs.Category (username, accountname, name, colour, icon, parentid)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING ` + categoryColumns
+
+	return scanCategory(db.QueryRowContext(ctx, query, username, account, name, colour, icon, parentID))
+}
+
+// UpdateCategory changes the name, colour, icon and parent of a category.
+// Renaming re-tags every transaction of the category, and is refused onto the
+// name of another category, which MergeCategories is for.
+func (c *Category) UpdateCategory(username string, account string, categoryID int, name string, colour string, icon string, parentID *int) (Category, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if err := validateCategoryFields(name, colour, icon); err != nil {
+		return Category{}, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Category{}, err
+	}
+	defer tx.Rollback()
+
+	if err := checkWritable(ctx, tx, username, account); err != nil {
+		return Category{}, err
+	}
+	current, err := getCategoryByID(ctx, tx, username, account, categoryID)
+	if err != nil {
+		return Category{}, err
+	}
+	if err := checkCategoryName(ctx, tx, username, account, categoryID, name); err != nil {
+		return Category{}, err
+	}
+	if err := checkParent(ctx, tx, username, account, categoryID, parentID); err != nil {
+		return Category{}, err
+	}
+
+	query := `UPDATE mrkrabs.Category SET name = $1, colour = $2, icon = $3, parentid = $4
+	WHERE categoryid = $5
+	RETURNING ` + categoryColumns
+
+	updated, err := scanCategory(tx.QueryRowContext(ctx, query, name, colour, icon, parentID, categoryID))
+	if err != nil {
+		return updated, err
+	}
+	if current.TransactionCategory != name {
+		if _, err := retagTransactions(ctx, tx, username, account, current.TransactionCategory, name); err != nil {
+			return updated, err
+		}
+	}
+	return updated, tx.Commit()
+}
+
+// MergeCategories re-tags every transaction of one category with another and
+// removes the first. It returns the number of transactions re-tagged.
+func (c *Category) MergeCategories(username string, account string, fromID int, intoID int) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if fromID == intoID {
+		return 0, errors.New("can not merge a category into itself")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	n, err := removeCategory(ctx, tx, username, account, fromID, &intoID)
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// DeleteCategory removes a category. Transactions still tagged with it are
+// re-tagged with reassignTo, and the delete is refused when there are such
+// transactions and no reassignTo is given.
+func (c *Category) DeleteCategory(username string, account string, categoryID int, reassignTo *int) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	n, err := removeCategory(ctx, tx, username, account, categoryID, reassignTo)
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+func removeCategory(ctx context.Context, tx *sql.Tx, username string, account string, categoryID int, reassignTo *int) (int64, error) {
+	if err := checkWritable(ctx, tx, username, account); err != nil {
+		return 0, err
+	}
+	category, err := getCategoryByID(ctx, tx, username, account, categoryID)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	if reassignTo != nil {
+		target, err := getCategoryByID(ctx, tx, username, account, *reassignTo)
+		if err != nil {
+			return 0, err
+		}
+		n, err = retagTransactions(ctx, tx, username, account, category.TransactionCategory, target.TransactionCategory)
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		var used bool
+		query := `SELECT EXISTS (SELECT 1 FROM mrkrabs.Transactions WHERE username = $1 AND accountname = $2 AND category = $3)
+			OR EXISTS (SELECT 1 FROM mrkrabs.TransactionSplit s JOIN mrkrabs.Transactions t ON t.transactionid = s.transactionid
+				WHERE t.username = $1 AND t.accountname = $2 AND s.category = $3)
+			OR EXISTS (SELECT 1 FROM mrkrabs.Rule WHERE username = $1 AND accountname = $2 AND setcategory = $3)
+			OR EXISTS (SELECT 1 FROM mrkrabs.EnvelopeAssignment WHERE username = $1 AND accountname = $2 AND category = $3)
+			OR EXISTS (SELECT 1 FROM mrkrabs.SavingsGoal WHERE username = $1 AND accountname = $2 AND category = $3)
+			OR EXISTS (SELECT 1 FROM mrkrabs.Payee WHERE username = $1 AND accountname = $2 AND category = $3)`
+		if err := tx.QueryRowContext(ctx, query, username, account, category.TransactionCategory).Scan(&used); err != nil {
+			return 0, err
+		}
+		if used {
+			return 0, fmt.Errorf("category %s is still used by transactions, rules, envelopes, goals or payees, choose a category to reassign them to", category.TransactionCategory)
+		}
+		if err := deleteBudgets(ctx, tx, username, account, category.TransactionCategory); err != nil {
+			return 0, err
+		}
+	}
+
+	// children of the removed category move up a level
+	_, err = tx.ExecContext(ctx, `UPDATE mrkrabs.Category SET parentid = $1 WHERE parentid = $2`, category.ParentID, categoryID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM mrkrabs.Category WHERE categoryid = $1`, categoryID)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// CategorySummary is the total of one category over a period, on its own and
+// rolled up with all categories below it.
+type CategorySummary struct {
+	CategoryID  int                `json:"categoryID"`
+	ParentID    *int               `json:"parentID,omitempty"`
+	Name        string             `json:"name"`
+	Total       float32            `json:"total"`
+	Count       int                `json:"count"`
+	RollupTotal float32            `json:"rollupTotal"`
+	RollupCount int                `json:"rollupCount"`
+	Children    []*CategorySummary `json:"children,omitempty"`
+}
+
+// GetCategorySummary returns the category tree of an account with the totals
+// of the transactions between from and to, counting the lines of split
+// transactions. Either bound may be nil.
+func (c *Category) GetCategorySummary(username string, account string, from *time.Time, to *time.Time) ([]*CategorySummary, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if err := seedCategories(ctx, db, username, account); err != nil {
+		return nil, err
+	}
+
+	query := `
+	WITH RECURSIVE own AS (
+		SELECT c.categoryid, c.parentid, c.name,
+			COALESCE(SUM(t.transactionamount), 0) AS total,
+			COUNT(t.transactionid) AS n
+		FROM mrkrabs.Category c
+		LEFT JOIN ` + categoryLines + ` t
+			ON t.username = c.username AND t.accountname = c.accountname AND t.category = c.name
+			AND ($3::timestamptz IS NULL OR t.transactiondate >= $3)
+			AND ($4::timestamptz IS NULL OR t.transactiondate < $4)
+		WHERE c.username = $1 AND c.accountname = $2
+		GROUP BY c.categoryid, c.parentid, c.name
+	), subtree AS (
+		SELECT categoryid AS root, categoryid AS node FROM own
+		UNION ALL
+		SELECT s.root, o.categoryid FROM subtree s JOIN own o ON o.parentid = s.node
+	)
+	SELECT o.categoryid, o.parentid, o.name, o.total, o.n, SUM(d.total), SUM(d.n)
+	FROM own o
+	JOIN subtree s ON s.root = o.categoryid
+	JOIN own d ON d.categoryid = s.node
+	GROUP BY o.categoryid, o.parentid, o.name, o.total, o.n
+	ORDER BY o.name`
+
+	rows, err := db.QueryContext(ctx, query, username, account, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []*CategorySummary
+	for rows.Next() {
+		var s CategorySummary
+		if err := rows.Scan(&s.CategoryID, &s.ParentID, &s.Name, &s.Total, &s.Count, &s.RollupTotal, &s.RollupCount); err != nil {
+			return nil, err
+		}
+		all = append(all, &s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	byID := map[int]*CategorySummary{}
+	for _, s := range all {
+		byID[s.CategoryID] = s
+	}
+	var roots []*CategorySummary
+	for _, s := range all {
+		if s.ParentID != nil && byID[*s.ParentID] != nil {
+			parent := byID[*s.ParentID]
+			parent.Children = append(parent.Children, s)
+			continue
+		}
+		roots = append(roots, s)
+	}
+	return roots, nil
+}
@@ -0,0 +1,229 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Envelope is the state of one category of an account in envelope mode.
+type Envelope struct {
+	Envelope string  `json:"envelope"`
+	Assigned float32 `json:"assigned"`
+	Spent    float32 `json:"spent"`
+	Balance  float32 `json:"balance"`
+}
+
+// EnvelopeSummary is the money of an account in envelope mode at the end of a
+// day. ToBeAssigned is the part of the balance not in any envelope.
+type EnvelopeSummary struct {
+	Date         string     `json:"date"`
+	ToBeAssigned float32    `json:"toBeAssigned"`
+	Envelopes    []Envelope `json:"envelopes"`
+}
+
+// checkEnvelopes enforces envelope mode on a transaction posted through
+// UpdateBalance: deposits must be split over envelopes to the cent and
+// withdrawals must name the envelope they are paid from.
+func (t *Account) checkEnvelopes(amount float32, category string, envelopes map[string]float32) error {
+	if !t.EnvelopeMode {
+		if len(envelopes) > 0 {
+			return errors.New("account is not in envelope mode")
+		}
+		return nil
+	}
+
+	if amount < 0 {
+		if len(envelopes) > 0 {
+			return errors.New("only deposits are assigned to envelopes")
+		}
+		if category == "" {
+			return errors.New("withdrawals from an account in envelope mode need a category to draw from")
+		}
+		return nil
+	}
+
+	var total float32
+	for name, amt := range envelopes {
+		if name == "" || amt <= 0 {
+			return errors.New("envelope assignments need a category and a positive amount")
+		}
+		total += amt
+	}
+	if diff := total - amount; diff > 0.005 || diff < -0.005 {
+		return fmt.Errorf("deposit of %.2f must be assigned to envelopes in full, %.2f was assigned", amount, total)
+	}
+	return nil
+}
+
+// assignEnvelopes moves money from to be assigned into envelopes, recording
+// the deposit it came from when there is one.
+func assignEnvelopes(ctx context.Context, tx *sql.Tx, username string, account string, envelopes map[string]float32, transactionID *int) error {
+	query := `INSERT INTO mrkrabs.EnvelopeAssignment (username, accountname, category, amount, transactionid)
+	VALUES ($1, $2, $3, $4, $5)`
+
+	for name, amount := range envelopes {
+		if err := validateCategory(ctx, tx, username, account, name); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, query, username, account, name, amount, transactionID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// envelopeBalances returns the envelopes of an account as they were before a
+// point in time. Withdrawals in a category that is not an envelope, such as
+// overdraft fees, are taken from to be assigned.
+func envelopeBalances(ctx context.Context, q querier, username string, account string, before time.Time) (EnvelopeSummary, error) {
+	summary := EnvelopeSummary{
+		Date:      before.AddDate(0, 0, -1).Format("2006-01-02"),
+		Envelopes: []Envelope{},
+	}
+
+	query := `SELECT c.name,
+		COALESCE((SELECT SUM(a.amount) FROM mrkrabs.EnvelopeAssignment a
+			WHERE a.username = c.username AND a.accountname = c.accountname AND a.category = c.name
+			AND a.assignedat < $3), 0),
+		COALESCE((SELECT -SUM(t.transactionamount) FROM ` + categoryLines + ` t
+			WHERE t.username = c.username AND t.accountname = c.accountname AND t.category = c.name
+			AND t.transactionamount < 0 AND t.transactiondate < $3), 0)
+	FROM mrkrabs.Category c
+	WHERE c.username = $1 AND c.accountname = $2
+	ORDER BY c.name`
+
+	rows, err := q.QueryContext(ctx, query, username, account, before)
+	if err != nil {
+		return summary, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e Envelope
+		if err := rows.Scan(&e.Envelope, &e.Assigned, &e.Spent); err != nil {
+			return summary, err
+		}
+		e.Balance = e.Assigned - e.Spent
+		summary.Envelopes = append(summary.Envelopes, e)
+	}
+	if err = rows.Err(); err != nil {
+		return summary, err
+	}
+
+	query = `SELECT
+		COALESCE((SELECT SUM(t.transactionamount) FROM ` + categoryLines + ` t
+			WHERE t.username = $1 AND t.accountname = $2 AND t.transactiondate < $3
+			AND (t.transactionamount > 0 OR NOT EXISTS (SELECT 1 FROM mrkrabs.Category c
+				WHERE c.username = $1 AND c.accountname = $2 AND c.name = t.category))), 0)
+		- COALESCE((SELECT SUM(a.amount) FROM mrkrabs.EnvelopeAssignment a
+			WHERE a.username = $1 AND a.accountname = $2 AND a.assignedat < $3), 0)`
+
+	err = q.QueryRowContext(ctx, query, username, account, before).Scan(&summary.ToBeAssigned)
+	return summary, err
+}
+
+// SetEnvelopeMode turns envelope mode on or off for an account. Assignments
+// made earlier are kept when it is turned off.
+func (t *Account) SetEnvelopeMode(email string, account_name string, enabled bool) (Account, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if err := checkWritable(ctx, db, email, account_name); err != nil {
+		return Account{}, err
+	}
+
+	query := `UPDATE mrkrabs.Account
+	SET envelopemode = $1
+	WHERE username = $2 AND accountname = $3
+	RETURNING ` + accountColumns
+
+	return scanAccount(db.QueryRowContext(ctx, query, enabled, email, account_name))
+}
+
+// GetEnvelopes returns the envelopes of an account at the end of the given
+// day.
+func (t *Account) GetEnvelopes(email string, account_name string, date time.Time) (EnvelopeSummary, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return envelopeBalances(ctx, db, email, account_name, date.AddDate(0, 0, 1))
+}
+
+// MoveEnvelopeMoney moves an amount between two envelopes of an account. An
+// empty from or to stands for to be assigned. The source must hold the
+// amount being moved.
+func (t *Account) MoveEnvelopeMoney(email string, account_name string, from string, to string, amount float32) (EnvelopeSummary, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if amount <= 0 {
+		return EnvelopeSummary{}, errors.New("amount to move must be positive")
+	}
+	if from == to {
+		return EnvelopeSummary{}, errors.New("can not move money to the envelope it comes from")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return EnvelopeSummary{}, err
+	}
+	defer tx.Rollback()
+
+	// the same lock postTransaction takes, so the balances can not change
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1 || '/' || $2))`, email, account_name)
+	if err != nil {
+		return EnvelopeSummary{}, err
+	}
+	if err := checkWritable(ctx, tx, email, account_name); err != nil {
+		return EnvelopeSummary{}, err
+	}
+	acct, err := accountOrDefault(ctx, tx, email, account_name)
+	if err != nil {
+		return EnvelopeSummary{}, err
+	}
+	if !acct.EnvelopeMode {
+		return EnvelopeSummary{}, errors.New("account is not in envelope mode")
+	}
+
+	// everything up to the end of today
+	tomorrow := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+	current, err := envelopeBalances(ctx, tx, email, account_name, tomorrow)
+	if err != nil {
+		return EnvelopeSummary{}, err
+	}
+	available := current.ToBeAssigned
+	if from != "" {
+		if err := validateCategory(ctx, tx, email, account_name, from); err != nil {
+			return EnvelopeSummary{}, err
+		}
+		available = 0
+		for _, e := range current.Envelopes {
+			if e.Envelope == from {
+				available = e.Balance
+			}
+		}
+	}
+	if amount > available {
+		return EnvelopeSummary{}, fmt.Errorf("can not move %.2f, only %.2f is available", amount, available)
+	}
+
+	moves := map[string]float32{}
+	if from != "" {
+		moves[from] = -amount
+	}
+	if to != "" {
+		moves[to] = amount
+	}
+	if err := assignEnvelopes(ctx, tx, email, account_name, moves, nil); err != nil {
+		return EnvelopeSummary{}, err
+	}
+
+	current, err = envelopeBalances(ctx, tx, email, account_name, tomorrow)
+	if err != nil {
+		return current, err
+	}
+	return current, tx.Commit()
+}
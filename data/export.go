@@ -0,0 +1,179 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	ExportTransactions = "transactions"
+	ExportCategories   = "categories"
+	ExportDebts        = "debts"
+	ExportRecurring    = "recurring"
+)
+
+// ExportDatasets lists what an account export can hold, in the order they are
+// written.
+var ExportDatasets = []string{ExportTransactions, ExportCategories, ExportDebts, ExportRecurring}
+
+// exportTimeout bounds an export, which runs as fast as the client reads it.
+const exportTimeout = 5 * time.Minute
+
+// ExportWriter receives an export as it is read from the database. Begin is
+// called before the rows of each dataset, with the names of its columns. The
+// values of a row are int, float32, string, bool, time.Time or nil.
+type ExportWriter interface {
+	Begin(dataset string, columns []string) error
+	Row(values []any) error
+}
+
+type exportDataset struct {
+	columns []string
+	query   string
+	// scan reads a row into values of the types ExportWriter expects
+	scan func(rows *sql.Rows) ([]any, error)
+}
+
+var exportQueries = map[string]exportDataset{
+	ExportTransactions: {
+		columns: []string{"transactionID", "date", "amount", "name", "description", "category", "tags", "externalID"},
+		query: `SELECT t.TransactionID, t.transactiondate, t.transactionamount, t.transactionname, t.transactiondescription, t.category,
+			COALESCE((SELECT string_agg(g.tag, ';' ORDER BY g.tag) FROM mrkrabs.TransactionTag g WHERE g.transactionid = t.TransactionID), ''),
+			COALESCE(t.externalid, '')
+		FROM mrkrabs.Transactions t
+		WHERE t.username = $1 AND t.accountname = $2
+		ORDER BY t.transactiondate, t.TransactionID`,
+		scan: func(rows *sql.Rows) ([]any, error) {
+			var id int
+			var date time.Time
+			var amount float32
+			var name, description, category, tags, externalID string
+			err := rows.Scan(&id, &date, &amount, &name, &description, &category, &tags, &externalID)
+			return []any{id, date, amount, name, description, category, tags, externalID}, err
+		},
+	},
+	ExportCategories: {
+		columns: []string{"categoryID", "name", "parentID", "colour", "icon"},
+		query: `SELECT categoryid, name, parentid, colour, icon FROM mrkrabs.Category
+		WHERE username = $1 AND accountname = $2
+		ORDER BY categoryid`,
+		scan: func(rows *sql.Rows) ([]any, error) {
+			var id int
+			var parentID sql.NullInt64
+			var name, colour, icon string
+			err := rows.Scan(&id, &name, &parentID, &colour, &icon)
+			return []any{id, name, nullInt(parentID), colour, icon}, err
+		},
+	},
+	// one row per payment, or a row without payment for a debt never paid
+	ExportDebts: {
+		columns: []string{"debtID", "name", "totalOwing", "paymentTransactionID", "paymentDate", "paymentAmount"},
+		query: `SELECT d.DebtID, d.Name, d.TotalOwing, t.TransactionID, t.transactiondate, -t.transactionamount
+		FROM mrkrabs.Debt d
+		LEFT JOIN mrkrabs.DebtPayment p ON p.DebtID = d.DebtID
+		LEFT JOIN mrkrabs.Transactions t ON t.TransactionID = p.TransactionID
+		WHERE d.UserID = $1 AND d.AccountName = $2
+		ORDER BY d.DebtID, t.transactiondate, t.TransactionID`,
+		scan: func(rows *sql.Rows) ([]any, error) {
+			var id int
+			var name string
+			var owing float32
+			var transactionID sql.NullInt64
+			var date sql.NullTime
+			var amount sql.NullFloat64
+			err := rows.Scan(&id, &name, &owing, &transactionID, &date, &amount)
+			return []any{id, name, owing, nullInt(transactionID), nullTime(date), nullFloat(amount)}, err
+		},
+	},
+	// one row per run of a payment, or a row without run for a payment that
+	// never ran
+	ExportRecurring: {
+		columns: []string{"paymentID", "name", "description", "amount", "type", "frequency", "startDate", "nextPaymentDate", "runDate", "runSucceeded"},
+		query: `SELECT p.paymentid, p.paymentname, p.paymentdescription, p.paymentamount, p.paymenttype, p.paymentfrequency, p.paymentdate, p.nextpaymentdate,
+			h.paymenthistorydate, h.paymenthistorystatus
+		FROM foreman.recurring_payment p
+		LEFT JOIN foreman.payment_history h ON h.paymentid = p.paymentid
+		WHERE p.username = $1 AND p.accountname = $2
+		ORDER BY p.paymentid, h.paymenthistorydate, h.paymenthistoryid`,
+		scan: func(rows *sql.Rows) ([]any, error) {
+			var id int
+			var name, description, paymentType, frequency, start, next string
+			var amount float32
+			var runDate sql.NullString
+			var status sql.NullBool
+			err := rows.Scan(&id, &name, &description, &amount, &paymentType, &frequency, &start, &next, &runDate, &status)
+			var succeeded any
+			if status.Valid {
+				succeeded = status.Bool
+			}
+			var ran any
+			if runDate.Valid {
+				ran = runDate.String
+			}
+			return []any{id, name, description, amount, paymentType, frequency, start, next, ran, succeeded}, err
+		},
+	},
+}
+
+func nullInt(n sql.NullInt64) any {
+	if !n.Valid {
+		return nil
+	}
+	return int(n.Int64)
+}
+
+func nullTime(t sql.NullTime) any {
+	if !t.Valid {
+		return nil
+	}
+	return t.Time
+}
+
+func nullFloat(f sql.NullFloat64) any {
+	if !f.Valid {
+		return nil
+	}
+	return float32(f.Float64)
+}
+
+// Export writes the datasets of an account to w, one row at a time, so the
+// size of an account does not matter.
+func (t *Transaction) Export(username string, account string, datasets []string, w ExportWriter) error {
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	for _, name := range datasets {
+		if _, ok := exportQueries[name]; !ok {
+			return fmt.Errorf("unknown export dataset %q", name)
+		}
+	}
+
+	for _, name := range datasets {
+		d := exportQueries[name]
+		rows, err := db.QueryContext(ctx, d.query, username, account)
+		if err != nil {
+			return err
+		}
+		if err := w.Begin(name, d.columns); err != nil {
+			rows.Close()
+			return err
+		}
+		for rows.Next() {
+			values, err := d.scan(rows)
+			if err == nil {
+				err = w.Row(values)
+			}
+			if err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,392 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// GoalPaymentType is the payment type of recurring payments that fund a
+// savings goal.
+const GoalPaymentType = "savings_goal"
+
+// Goal is an amount to be saved in an account by a target date. With a
+// Category, only that category counts towards the goal, or its envelope
+// balance when the account is in envelope mode. Without one, the whole
+// balance of the account counts.
+type Goal struct {
+	GoalID       int       `json:"goalID"`
+	Username     string    `json:"username"`
+	AccountName  string    `json:"accountname"`
+	Name         string    `json:"name"`
+	TargetAmount float32   `json:"targetAmount"`
+	TargetDate   string    `json:"targetDate"`
+	Category     *string   `json:"category,omitempty"`
+	PaymentID    *int      `json:"paymentID,omitempty"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// GoalProgress is how far a goal is. A goal is on track while the amount
+// saved keeps up with saving evenly from its creation to its target date.
+type GoalProgress struct {
+	Goal            Goal    `json:"goal"`
+	Saved           float32 `json:"saved"`
+	Remaining       float32 `json:"remaining"`
+	PercentComplete float32 `json:"percentComplete"`
+	MonthsLeft      int     `json:"monthsLeft"`
+	RequiredMonthly float32 `json:"requiredMonthly"`
+	ExpectedByNow   float32 `json:"expectedByNow"`
+	OnTrack         bool    `json:"onTrack"`
+}
+
+const goalColumns = `goalid, username, accountname, name, targetamount, to_char(targetdate, 'YYYY-MM-DD'), category, paymentid, createdat`
+
+func scanGoal(row interface{ Scan(...any) error }) (Goal, error) {
+	var g Goal
+	err := row.Scan(&g.GoalID, &g.Username, &g.AccountName, &g.Name, &g.TargetAmount, &g.TargetDate, &g.Category, &g.PaymentID, &g.CreatedAt)
+	return g, err
+}
+
+func validateGoal(name string, targetAmount float32, targetDate string) error {
+	if name == "" {
+		return errors.New("a goal needs a name")
+	}
+	if targetAmount <= 0 {
+		return errors.New("goal target amount must be positive")
+	}
+	if _, err := time.Parse("2006-01-02", targetDate); err != nil {
+		return err
+	}
+	return nil
+}
+
+// monthsLeft returns the number of months, rounded up, from now until the
+// target date, and 0 once it has passed.
+func monthsLeft(now time.Time, target time.Time) int {
+	if !now.Before(target) {
+		return 0
+	}
+	months := (target.Year()-now.Year())*12 + int(target.Month()-now.Month())
+	if now.AddDate(0, months, 0).Before(target) {
+		months++
+	}
+	if months < 1 {
+		months = 1
+	}
+	return months
+}
+
+// goalSaved returns the amount currently counting towards a goal.
+func goalSaved(ctx context.Context, q querier, g Goal) (float32, error) {
+	var saved float32
+
+	if g.Category == nil {
+		query := `SELECT COALESCE(SUM(transactionamount), 0) FROM mrkrabs.Transactions
+		WHERE username = $1 AND accountname = $2`
+		err := q.QueryRowContext(ctx, query, g.Username, g.AccountName).Scan(&saved)
+		return saved, err
+	}
+
+	var envelopeMode bool
+	query := `SELECT envelopemode FROM mrkrabs.Account WHERE username = $1 AND accountname = $2`
+	err := q.QueryRowContext(ctx, query, g.Username, g.AccountName).Scan(&envelopeMode)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	if envelopeMode {
+		summary, err := envelopeBalances(ctx, q, g.Username, g.AccountName, time.Now().AddDate(0, 0, 1))
+		if err != nil {
+			return 0, err
+		}
+		for _, e := range summary.Envelopes {
+			if e.Envelope == *g.Category {
+				return e.Balance, nil
+			}
+		}
+		return 0, nil
+	}
+
+	query = `SELECT COALESCE(SUM(transactionamount), 0) FROM ` + categoryLines + ` t
+	WHERE username = $1 AND accountname = $2 AND category = $3`
+	err = q.QueryRowContext(ctx, query, g.Username, g.AccountName, *g.Category).Scan(&saved)
+	return saved, err
+}
+
+func (g Goal) progress(saved float32, now time.Time) GoalProgress {
+	p := GoalProgress{Goal: g, Saved: saved}
+
+	p.Remaining = g.TargetAmount - saved
+	if p.Remaining < 0 {
+		p.Remaining = 0
+	}
+	p.PercentComplete = saved / g.TargetAmount * 100
+
+	target, _ := time.Parse("2006-01-02", g.TargetDate)
+	p.MonthsLeft = monthsLeft(now, target)
+	switch {
+	case p.Remaining == 0:
+	case p.MonthsLeft > 0:
+		p.RequiredMonthly = float32(math.Ceil(float64(p.Remaining/float32(p.MonthsLeft))*100) / 100)
+	default:
+		p.RequiredMonthly = p.Remaining
+	}
+
+	p.ExpectedByNow = g.TargetAmount
+	if total := target.Sub(g.CreatedAt); total > 0 && now.Before(target) {
+		p.ExpectedByNow = g.TargetAmount * float32(now.Sub(g.CreatedAt)) / float32(total)
+	}
+	p.OnTrack = saved >= p.ExpectedByNow
+	return p
+}
+
+// checkAutoFund refuses funding a goal with recurring payments unless the
+// payments, posted in the category of the goal, count towards it.
+func checkAutoFund(ctx context.Context, q querier, username string, account string, category *string) error {
+	if category == nil {
+		return errors.New("only a goal with a category can be funded automatically")
+	}
+	var envelopeMode bool
+	query := `SELECT envelopemode FROM mrkrabs.Account WHERE username = $1 AND accountname = $2`
+	err := q.QueryRowContext(ctx, query, username, account).Scan(&envelopeMode)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if envelopeMode {
+		return errors.New("goals of an account in envelope mode can not be funded automatically, assign money to their envelope instead")
+	}
+	return nil
+}
+
+func getGoal(ctx context.Context, q querier, username string, account string, goalID int) (Goal, error) {
+	query := `SELECT ` + goalColumns + ` FROM mrkrabs.SavingsGoal
+	WHERE goalid = $1 AND username = $2 AND accountname = $3`
+
+	g, err := scanGoal(q.QueryRowContext(ctx, query, goalID, username, account))
+	if errors.Is(err, sql.ErrNoRows) {
+		return g, fmt.Errorf("goal %d not found", goalID)
+	}
+	return g, err
+}
+
+func (g *Goal) GetGoals(username string, account string) ([]GoalProgress, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	query := `SELECT ` + goalColumns + ` FROM mrkrabs.SavingsGoal
+	WHERE username = $1 AND accountname = $2
+	ORDER BY targetdate, goalid`
+
+	rows, err := db.QueryContext(ctx, query, username, account)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var goals []Goal
+	for rows.Next() {
+		goal, err := scanGoal(rows)
+		if err != nil {
+			return nil, err
+		}
+		goals = append(goals, goal)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var progress []GoalProgress
+	for _, goal := range goals {
+		saved, err := goalSaved(ctx, db, goal)
+		if err != nil {
+			return progress, err
+		}
+		progress = append(progress, goal.progress(saved, now))
+	}
+	return progress, nil
+}
+
+func (g *Goal) GetGoal(username string, account string, goalID int) (GoalProgress, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	goal, err := getGoal(ctx, db, username, account, goalID)
+	if err != nil {
+		return GoalProgress{}, err
+	}
+	saved, err := goalSaved(ctx, db, goal)
+	if err != nil {
+		return GoalProgress{}, err
+	}
+	return goal.progress(saved, time.Now()), nil
+}
+
+// CreateGoal adds a savings goal to an account. With autoFund set, a monthly
+// recurring payment of the required contribution is created to fund it, in
+// the category of the goal so that the payments count towards it. A goal
+// counting the whole balance can not be funded that way, since the payments
+// would come from nowhere, and neither can one in envelope mode, where money
+// only reaches an envelope by being assigned to it.
+func (g *Goal) CreateGoal(username string, account string, name string, targetAmount float32, targetDate string, category *string, autoFund bool) (GoalProgress, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if err := validateGoal(name, targetAmount, targetDate); err != nil {
+		return GoalProgress{}, err
+	}
+	if category != nil && *category == "" {
+		category = nil
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return GoalProgress{}, err
+	}
+	defer tx.Rollback()
+
+	if err := checkWritable(ctx, tx, username, account); err != nil {
+		return GoalProgress{}, err
+	}
+	if category != nil {
+		if err := validateCategory(ctx, tx, username, account, *category); err != nil {
+			return GoalProgress{}, err
+		}
+	}
+	if autoFund {
+		if err := checkAutoFund(ctx, tx, username, account, category); err != nil {
+			return GoalProgress{}, err
+		}
+	}
+
+	now := time.Now()
+	goal := Goal{Username: username, AccountName: account, Name: name, TargetAmount: targetAmount, TargetDate: targetDate, Category: category, CreatedAt: now}
+	saved, err := goalSaved(ctx, tx, goal)
+	if err != nil {
+		return GoalProgress{}, err
+	}
+
+	if autoFund {
+		contribution := goal.progress(saved, now).RequiredMonthly
+		if contribution <= 0 {
+			return GoalProgress{}, errors.New("goal is already reached or its target date has passed, there is nothing to fund")
+		}
+
+		paymentDate := now.Format("2006-01-02")
+		query := `INSERT INTO foreman.recurring_payment(
+			username, accountname, paymentamount, paymentname, paymentdescription, paymentdate, paymenttype, paymentfrequency, nextpaymentdate, paymentcategory)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			RETURNING paymentid`
+
+		var paymentID int
+		err := tx.QueryRowContext(ctx, query, username, account, contribution, name, fmt.Sprintf("savings goal %s", name), paymentDate, GoalPaymentType, "monthly", nextPaymentDate(paymentDate, "monthly"), *category).Scan(&paymentID)
+		if err != nil {
+			return GoalProgress{}, err
+		}
+		goal.PaymentID = &paymentID
+	}
+
+	query := `INSERT INTO mrkrabs.SavingsGoal (username, accountname, name, targetamount, targetdate, category, paymentid, createdat)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	RETURNING ` + goalColumns
+
+	goal, err = scanGoal(tx.QueryRowContext(ctx, query, username, account, name, targetAmount, targetDate, category, goal.PaymentID, now))
+	if err != nil {
+		return GoalProgress{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return GoalProgress{}, err
+	}
+	return goal.progress(saved, now), nil
+}
+
+// UpdateGoal changes a goal. The amount of a recurring payment funding it is
+// left as it is, but the payment follows the goal to its new category.
+func (g *Goal) UpdateGoal(username string, account string, goalID int, name string, targetAmount float32, targetDate string, category *string) (GoalProgress, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if err := validateGoal(name, targetAmount, targetDate); err != nil {
+		return GoalProgress{}, err
+	}
+	if category != nil && *category == "" {
+		category = nil
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return GoalProgress{}, err
+	}
+	defer tx.Rollback()
+
+	if err := checkWritable(ctx, tx, username, account); err != nil {
+		return GoalProgress{}, err
+	}
+	if category != nil {
+		if err := validateCategory(ctx, tx, username, account, *category); err != nil {
+			return GoalProgress{}, err
+		}
+	}
+	goal, err := getGoal(ctx, tx, username, account, goalID)
+	if err != nil {
+		return GoalProgress{}, err
+	}
+	if goal.PaymentID != nil {
+		if err := checkAutoFund(ctx, tx, username, account, category); err != nil {
+			return GoalProgress{}, err
+		}
+		query := `UPDATE foreman.recurring_payment SET paymentcategory = $1 WHERE paymentid = $2 AND username = $3`
+		if _, err := tx.ExecContext(ctx, query, *category, *goal.PaymentID, username); err != nil {
+			return GoalProgress{}, err
+		}
+	}
+
+	query := `UPDATE mrkrabs.SavingsGoal
+	SET name = $1, targetamount = $2, targetdate = $3, category = $4
+	WHERE goalid = $5 AND username = $6 AND accountname = $7
+	RETURNING ` + goalColumns
+
+	goal, err = scanGoal(tx.QueryRowContext(ctx, query, name, targetAmount, targetDate, category, goalID, username, account))
+	if err != nil {
+		return GoalProgress{}, err
+	}
+	saved, err := goalSaved(ctx, tx, goal)
+	if err != nil {
+		return GoalProgress{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return GoalProgress{}, err
+	}
+	return goal.progress(saved, time.Now()), nil
+}
+
+// DeleteGoal removes a goal along with the recurring payment funding it.
+func (g *Goal) DeleteGoal(username string, account string, goalID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkWritable(ctx, tx, username, account); err != nil {
+		return err
+	}
+	goal, err := getGoal(ctx, tx, username, account, goalID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mrkrabs.SavingsGoal WHERE goalid = $1`, goalID); err != nil {
+		return err
+	}
+	if goal.PaymentID != nil {
+		query := `DELETE FROM foreman.recurring_payment WHERE paymentid = $1 AND username = $2`
+		if _, err := tx.ExecContext(ctx, query, *goal.PaymentID, username); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,241 @@
+package data
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// camtDate is a date of a camt.053 statement, given either as a day or as a
+// day and time.
+type camtDate struct {
+	Dt   string `xml:"Dt"`
+	DtTm string `xml:"DtTm"`
+}
+
+func (d camtDate) day() (time.Time, error) {
+	s := d.Dt
+	if s == "" && len(d.DtTm) >= 10 {
+		s = d.DtTm[:10]
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", s)
+	}
+	return t, nil
+}
+
+// camtAmount is an amount of a camt.053 statement with the indicator telling
+// whether it is a credit or a debit.
+type camtAmount struct {
+	Amt       string `xml:"Amt"`
+	CdtDbtInd string `xml:"CdtDbtInd"`
+}
+
+func (a camtAmount) signed() (float32, error) {
+	amount, err := parseImportAmount(a.Amt)
+	if err != nil {
+		return 0, err
+	}
+	if a.CdtDbtInd == "DBIT" {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+type camtParty struct {
+	Nm  string `xml:"Nm"`
+	Pty struct {
+		Nm string `xml:"Nm"`
+	} `xml:"Pty"`
+}
+
+func (p camtParty) name() string {
+	if p.Nm != "" {
+		return p.Nm
+	}
+	return p.Pty.Nm
+}
+
+type camtStatement struct {
+	ID   string `xml:"Id"`
+	Acct struct {
+		ID struct {
+			IBAN string `xml:"IBAN"`
+			Othr struct {
+				ID string `xml:"Id"`
+			} `xml:"Othr"`
+		} `xml:"Id"`
+	} `xml:"Acct"`
+	Bal []struct {
+		camtAmount
+		Tp struct {
+			CdOrPrtry struct {
+				Cd string `xml:"Cd"`
+			} `xml:"CdOrPrtry"`
+		} `xml:"Tp"`
+		Dt camtDate `xml:"Dt"`
+	} `xml:"Bal"`
+	Ntry []struct {
+		camtAmount
+		NtryRef     string `xml:"NtryRef"`
+		AcctSvcrRef string `xml:"AcctSvcrRef"`
+		// Sts is a code of its own before version 8 of camt.053
+		Sts struct {
+			Text string `xml:",chardata"`
+			Cd   string `xml:"Cd"`
+		} `xml:"Sts"`
+		BookgDt      camtDate `xml:"BookgDt"`
+		AddtlNtryInf string   `xml:"AddtlNtryInf"`
+		TxDtls       []struct {
+			RmtInf struct {
+				Ustrd []string `xml:"Ustrd"`
+			} `xml:"RmtInf"`
+			RltdPties struct {
+				Dbtr camtParty `xml:"Dbtr"`
+				Cdtr camtParty `xml:"Cdtr"`
+			} `xml:"RltdPties"`
+		} `xml:"NtryDtls>TxDtls"`
+	} `xml:"Ntry"`
+}
+
+func (s camtStatement) account() string {
+	if s.Acct.ID.IBAN != "" {
+		return s.Acct.ID.IBAN
+	}
+	return s.Acct.ID.Othr.ID
+}
+
+// parseCAMT reads the booked entries of a camt.053 statement, along with its
+// opening and closing balances. A file holding the statements of several
+// accounts needs the IBAN, or other id, of the account to import.
+//
+// Entries are named after the other party and described by their remittance
+// information. Batched entries are imported as one transaction.
+func parseCAMT(r io.Reader, iban string) ([]ImportRow, []BalanceCheck, error) {
+	var doc struct {
+		Stmt []camtStatement `xml:"BkToCstmrStmt>Stmt"`
+	}
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, nil, fmt.Errorf("file is not a camt.053 statement: %w", err)
+	}
+
+	var statements []camtStatement
+	for _, s := range doc.Stmt {
+		if iban == "" || strings.EqualFold(strings.ReplaceAll(s.account(), " ", ""), strings.ReplaceAll(iban, " ", "")) {
+			statements = append(statements, s)
+		}
+	}
+	if len(statements) == 0 && iban != "" {
+		return nil, nil, fmt.Errorf("file holds no statement for account %s", iban)
+	}
+	if len(statements) == 0 {
+		return nil, nil, errors.New("file holds no statement")
+	}
+	if len(statements) > 1 && iban == "" {
+		return nil, nil, fmt.Errorf("file holds %d statements, choose the account to import", len(statements))
+	}
+
+	var rows []ImportRow
+	var checks []BalanceCheck
+	for _, s := range statements {
+		var opening, closing *BalanceCheck
+		for _, b := range s.Bal {
+			code := b.Tp.CdOrPrtry.Cd
+			if code != "OPBD" && code != "PRCD" && code != "CLBD" {
+				continue
+			}
+			amount, err := b.signed()
+			if err != nil {
+				return nil, nil, fmt.Errorf("statement %s: balance %s: %w", s.ID, code, err)
+			}
+			day, err := b.Dt.day()
+			if err != nil {
+				return nil, nil, fmt.Errorf("statement %s: balance %s: %w", s.ID, code, err)
+			}
+			switch code {
+			case "OPBD", "PRCD":
+				// the opening balance comes before the entries booked that day,
+				// while the closing balance of the day before holds them all
+				if code == "PRCD" {
+					day = day.AddDate(0, 0, 1)
+				}
+				opening = &BalanceCheck{Label: fmt.Sprintf("opening balance of statement %s", s.ID), AsOf: day, Statement: amount}
+			case "CLBD":
+				closing = &BalanceCheck{Label: fmt.Sprintf("closing balance of statement %s", s.ID), AsOf: day.AddDate(0, 0, 1), Statement: amount}
+			}
+		}
+
+		var booked float32
+		for _, e := range s.Ntry {
+			status := strings.TrimSpace(e.Sts.Text)
+			if e.Sts.Cd != "" {
+				status = e.Sts.Cd
+			}
+			if status != "" && status != "BOOK" {
+				continue
+			}
+
+			row := ImportRow{Row: len(rows) + 1, ExternalID: e.AcctSvcrRef}
+			if row.ExternalID == "" {
+				row.ExternalID = e.NtryRef
+			}
+
+			var remittance []string
+			for _, d := range e.TxDtls {
+				remittance = append(remittance, d.RmtInf.Ustrd...)
+				party := d.RltdPties.Cdtr.name()
+				if e.CdtDbtInd == "CRDT" {
+					party = d.RltdPties.Dbtr.name()
+				}
+				if row.Name == "" {
+					row.Name = strings.TrimSpace(party)
+				}
+			}
+			row.Description = strings.TrimSpace(strings.Join(remittance, " "))
+			if row.Description == "" {
+				row.Description = strings.TrimSpace(e.AddtlNtryInf)
+			}
+			if row.Name == "" {
+				row.Name = row.Description
+			}
+
+			var err error
+			if row.Amount, err = e.signed(); err != nil {
+				row.Error = err.Error()
+			} else if row.Date, err = e.BookgDt.day(); err != nil {
+				row.Error = err.Error()
+			}
+			booked += row.Amount
+			rows = append(rows, row)
+		}
+
+		// a statement missing some of its entries can not be trusted at all
+		if opening != nil && closing != nil && math.Round(float64(opening.Statement+booked-closing.Statement)*100) != 0 {
+			return nil, nil, fmt.Errorf("statement %s: entries add up to %.2f but the balance went from %.2f to %.2f",
+				s.ID, booked, opening.Statement, closing.Statement)
+		}
+		if opening != nil {
+			checks = append(checks, *opening)
+		}
+		if closing != nil {
+			checks = append(checks, *closing)
+		}
+	}
+	return rows, checks, nil
+}
+
+// ImportCAMT imports the booked entries of a camt.053 statement. Entries are
+// matched on the reference given by the bank, and the opening and closing
+// balances of the statement are checked against the balances of the account.
+func (t *Transaction) ImportCAMT(username string, account string, file io.Reader, iban string, dryRun bool) (ImportResult, error) {
+	rows, checks, err := parseCAMT(file, iban)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	return importTransactions(username, account, rows, checks, dryRun)
+}
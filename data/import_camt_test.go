@@ -0,0 +1,115 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// camtFile wraps statements in a camt.053 document.
+func camtFile(statements ...string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.053.001.02"><BkToCstmrStmt>
+<GrpHdr><MsgId>MSG1</MsgId></GrpHdr>` + strings.Join(statements, "\n") + `</BkToCstmrStmt></Document>`
+}
+
+// camtStatementXML is a statement of an account opening at 100.00 on 1 March
+// 2024, with the closing balance given.
+func camtStatementXML(id string, iban string, closing string, entries string) string {
+	return fmt.Sprintf(`<Stmt><Id>%s</Id><Acct><Id><IBAN>%s</IBAN></Id></Acct>
+<Bal><Tp><CdOrPrtry><Cd>OPBD</Cd></CdOrPrtry></Tp><Amt Ccy="EUR">100.00</Amt><CdtDbtInd>CRDT</CdtDbtInd><Dt><Dt>2024-03-01</Dt></Dt></Bal>
+<Bal><Tp><CdOrPrtry><Cd>CLBD</Cd></CdOrPrtry></Tp><Amt Ccy="EUR">%s</Amt><CdtDbtInd>CRDT</CdtDbtInd><Dt><Dt>2024-03-31</Dt></Dt></Bal>
+%s</Stmt>`, id, iban, closing, entries)
+}
+
+const (
+	camtDebit = `<Ntry><NtryRef>N1</NtryRef><Amt Ccy="EUR">25.50</Amt><CdtDbtInd>DBIT</CdtDbtInd><Sts>BOOK</Sts>
+<BookgDt><Dt>2024-03-04</Dt></BookgDt><AcctSvcrRef>REF1</AcctSvcrRef>
+<NtryDtls><TxDtls><RltdPties><Dbtr><Nm>Me</Nm></Dbtr><Cdtr><Nm>Grocer</Nm></Cdtr></RltdPties>
+<RmtInf><Ustrd>invoice 42</Ustrd><Ustrd>thanks</Ustrd></RmtInf></TxDtls></NtryDtls></Ntry>`
+	camtCredit = `<Ntry><NtryRef>N2</NtryRef><Amt Ccy="EUR">1000.00</Amt><CdtDbtInd>CRDT</CdtDbtInd><Sts><Cd>BOOK</Cd></Sts>
+<BookgDt><DtTm>2024-03-15T09:30:00+01:00</DtTm></BookgDt>
+<NtryDtls><TxDtls><RltdPties><Dbtr><Pty><Nm>Employer</Nm></Pty></Dbtr><Cdtr><Nm>Me</Nm></Cdtr></RltdPties></TxDtls></NtryDtls>
+<AddtlNtryInf>SALARY MARCH</AddtlNtryInf></Ntry>`
+	camtPending = `<Ntry><NtryRef>N3</NtryRef><Amt Ccy="EUR">9.99</Amt><CdtDbtInd>DBIT</CdtDbtInd><Sts>PDNG</Sts>
+<BookgDt><Dt>2024-03-30</Dt></BookgDt><AddtlNtryInf>CARD PAYMENT</AddtlNtryInf></Ntry>`
+)
+
+func TestParseCAMT(t *testing.T) {
+	day := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	}
+	booked := []ImportRow{
+		{Row: 1, Date: day(2024, 3, 4), Amount: -25.50, Name: "Grocer", Description: "invoice 42 thanks", ExternalID: "REF1"},
+		{Row: 2, Date: day(2024, 3, 15), Amount: 1000, Name: "Employer", Description: "SALARY MARCH", ExternalID: "N2"},
+	}
+	balances := func(id string) []BalanceCheck {
+		return []BalanceCheck{
+			{Label: "opening balance of statement " + id, AsOf: day(2024, 3, 1), Statement: 100},
+			{Label: "closing balance of statement " + id, AsOf: day(2024, 4, 1), Statement: 1074.50},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		file       string
+		iban       string
+		want       []ImportRow
+		wantChecks []BalanceCheck
+		wantErr    string
+	}{
+		{
+			name:       "debits, credits and a pending entry",
+			file:       camtFile(camtStatementXML("S1", "DE89370400440532013000", "1074.50", camtDebit+camtCredit+camtPending)),
+			want:       booked,
+			wantChecks: balances("S1"),
+		},
+		{
+			name:    "balances do not match the entries",
+			file:    camtFile(camtStatementXML("S1", "DE89370400440532013000", "1100.00", camtDebit+camtCredit)),
+			wantErr: "statement S1: entries add up to 974.50 but the balance went from 100.00 to 1100.00",
+		},
+		{
+			name: "account chosen by IBAN",
+			file: camtFile(
+				camtStatementXML("S1", "DE89370400440532013000", "1074.50", camtDebit+camtCredit),
+				camtStatementXML("S2", "NL91ABNA0417164300", "100.00", ""),
+			),
+			iban:       "de89 3704 0044 0532 0130 00",
+			want:       booked,
+			wantChecks: balances("S1"),
+		},
+		{
+			name: "several accounts",
+			file: camtFile(
+				camtStatementXML("S1", "DE89370400440532013000", "1074.50", camtDebit+camtCredit),
+				camtStatementXML("S2", "NL91ABNA0417164300", "100.00", ""),
+			),
+			wantErr: "file holds 2 statements, choose the account to import",
+		},
+		{
+			name:    "no statement for the account",
+			file:    camtFile(camtStatementXML("S1", "DE89370400440532013000", "1074.50", camtDebit+camtCredit)),
+			iban:    "NL91ABNA0417164300",
+			wantErr: "file holds no statement for account NL91ABNA0417164300",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, checks, err := parseCAMT(strings.NewReader(tt.file), tt.iban)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("parseCAMT error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			compareImportRows(t, rows, tt.want)
+			compareBalanceChecks(t, checks, tt.wantChecks)
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ImportMapping tells how to read the CSV files of a bank. Columns are named
+// by their header. Amounts come either from one signed AmountColumn or from
+// separate DebitColumn and CreditColumn. When NameColumn is empty the
+// description is used as the name of the transactions.
+type ImportMapping struct {
+	MappingID         int    `json:"mappingID"`
+	Username          string `json:"username"`
+	AccountName       string `json:"accountname"`
+	Name              string `json:"name"`
+	DateColumn        string `json:"dateColumn"`
+	DateFormat        string `json:"dateFormat"`
+	AmountColumn      string `json:"amountColumn"`
+	DebitColumn       string `json:"debitColumn"`
+	CreditColumn      string `json:"creditColumn"`
+	DescriptionColumn string `json:"descriptionColumn"`
+	NameColumn        string `json:"nameColumn"`
+	CategoryColumn    string `json:"categoryColumn"`
+	Delimiter         string `json:"delimiter"`
+}
+
+const importMappingColumns = `mappingid, username, accountname, name, datecolumn, dateformat, amountcolumn, debitcolumn, creditcolumn,
+	descriptioncolumn, namecolumn, categorycolumn, delimiter`
+
+func scanImportMapping(row interface{ Scan(...any) error }) (ImportMapping, error) {
+	var m ImportMapping
+	err := row.Scan(&m.MappingID, &m.Username, &m.AccountName, &m.Name, &m.DateColumn, &m.DateFormat, &m.AmountColumn, &m.DebitColumn, &m.CreditColumn,
+		&m.DescriptionColumn, &m.NameColumn, &m.CategoryColumn, &m.Delimiter)
+	return m, err
+}
+
+func (m *ImportMapping) validate() error {
+	if m.Name == "" {
+		return errors.New("a mapping needs a name")
+	}
+	if m.DateColumn == "" || m.DescriptionColumn == "" {
+		return errors.New("a mapping needs a date and a description column")
+	}
+	if (m.AmountColumn == "") == (m.DebitColumn == "" && m.CreditColumn == "") {
+		return errors.New("a mapping needs either an amount column or debit and credit columns")
+	}
+	if m.DateFormat == "" {
+		m.DateFormat = "2006-01-02"
+	}
+	if m.Delimiter == "" {
+		m.Delimiter = ","
+	}
+	if utf8.RuneCountInString(m.Delimiter) != 1 {
+		return errors.New("delimiter must be a single character")
+	}
+	return nil
+}
+
+// parseImportAmount reads an amount as banks write it, allowing currency
+// signs, thousands separators and parentheses around negative amounts.
+func parseImportAmount(s string) (float32, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")")
+	s = strings.Trim(s, "()")
+	s = strings.NewReplacer("$", "", "€", "", "£", "", ",", "", " ", "").Replace(s)
+	if s == "" {
+		return 0, nil
+	}
+
+	f, err := strconv.ParseFloat(s, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if negative {
+		f = -f
+	}
+	return float32(f), nil
+}
+
+// parse reads the transactions of a CSV file. Rows that can not be read are
+// returned with an error instead of stopping the import.
+func (m *ImportMapping) parse(r io.Reader) ([]ImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.Comma, _ = utf8.DecodeRuneInString(m.Delimiter)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	index := map[string]int{}
+	for i, name := range header {
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	// column returns a function reading the named column of a record, which
+	// reads nothing for columns the mapping leaves empty
+	var missing []string
+	column := func(name string) func([]string) string {
+		if name == "" {
+			return func([]string) string { return "" }
+		}
+		i, ok := index[strings.ToLower(name)]
+		if !ok {
+			missing = append(missing, name)
+		}
+		return func(record []string) string {
+			if i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+	}
+	date, amount, debit, credit := column(m.DateColumn), column(m.AmountColumn), column(m.DebitColumn), column(m.CreditColumn)
+	description, name, category := column(m.DescriptionColumn), column(m.NameColumn), column(m.CategoryColumn)
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("file has no column %s", strings.Join(missing, ", "))
+	}
+
+	var rows []ImportRow
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) == 1 && record[0] == "" {
+			continue
+		}
+
+		row := ImportRow{Row: line, Description: description(record), Name: name(record), Category: category(record)}
+		if row.Name == "" {
+			row.Name = row.Description
+		}
+
+		row.Date, err = time.Parse(m.DateFormat, date(record))
+		if err != nil {
+			row.Error = fmt.Sprintf("invalid date %q, expected %s", date(record), m.DateFormat)
+			rows = append(rows, row)
+			continue
+		}
+
+		if m.AmountColumn != "" {
+			row.Amount, err = parseImportAmount(amount(record))
+		} else {
+			var d, c float32
+			d, err = parseImportAmount(debit(record))
+			if err == nil {
+				c, err = parseImportAmount(credit(record))
+			}
+			// debits are money out whatever their sign in the file
+			row.Amount = c - abs32(d)
+		}
+		if err != nil {
+			row.Error = err.Error()
+		} else if row.Amount == 0 {
+			row.Error = "transaction has no amount"
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func (m *ImportMapping) GetImportMappings(username string, account string) ([]ImportMapping, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	query := `SELECT ` + importMappingColumns + ` FROM mrkrabs.ImportMapping
+	WHERE username = $1 AND accountname = $2 ORDER BY name, mappingid`
+
+	rows, err := db.QueryContext(ctx, query, username, account)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mappings []ImportMapping
+	for rows.Next() {
+		mapping, err := scanImportMapping(rows)
+		if err != nil {
+			return mappings, err
+		}
+		mappings = append(mappings, mapping)
+	}
+	if err = rows.Err(); err != nil {
+		return mappings, err
+	}
+	return mappings, nil
+}
+
+func (m *ImportMapping) CreateImportMapping(username string, account string, mapping ImportMapping) (ImportMapping, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if err := mapping.validate(); err != nil {
+		return ImportMapping{}, err
+	}
+
+	query := `INSERT INTO mrkrabs.ImportMapping (username, accountname, name, datecolumn, dateformat, amountcolumn, debitcolumn, creditcolumn,
+		descriptioncolumn, namecolumn, categorycolumn, delimiter)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	RETURNING ` + importMappingColumns
+
+	return scanImportMapping(db.QueryRowContext(ctx, query, username, account, mapping.Name, mapping.DateColumn, mapping.DateFormat, mapping.AmountColumn, mapping.DebitColumn, mapping.CreditColumn,
+		mapping.DescriptionColumn, mapping.NameColumn, mapping.CategoryColumn, mapping.Delimiter))
+}
+
+func (m *ImportMapping) DeleteImportMapping(username string, account string, mappingID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	query := `DELETE FROM mrkrabs.ImportMapping WHERE mappingid = $1 AND username = $2 AND accountname = $3`
+
+	res, err := db.ExecContext(ctx, query, mappingID, username, account)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("mapping %d not found", mappingID)
+	}
+	return nil
+}
+
+// ImportCSV imports the transactions of a CSV file read with a saved mapping.
+// See importTransactions for how rows are checked and posted.
+func (t *Transaction) ImportCSV(username string, account string, mappingID int, file io.Reader, dryRun bool) (ImportResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	query := `SELECT ` + importMappingColumns + ` FROM mrkrabs.ImportMapping
+	WHERE mappingid = $1 AND username = $2 AND accountname = $3`
+	mapping, err := scanImportMapping(db.QueryRowContext(ctx, query, mappingID, username, account))
+	cancel()
+	if errors.Is(err, sql.ErrNoRows) {
+		return ImportResult{}, fmt.Errorf("mapping %d not found", mappingID)
+	}
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	rows, err := mapping.parse(file)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	return importTransactions(username, account, rows, nil, dryRun)
+}
@@ -0,0 +1,147 @@
+package data
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseImportAmount(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    float32
+		wantErr bool
+	}{
+		{"12.50", 12.50, false},
+		{"-12.50", -12.50, false},
+		{"(12.50)", -12.50, false},
+		{"$1,234.56", 1234.56, false},
+		{"($1,234.56)", -1234.56, false},
+		{" €7 ", 7, false},
+		{"£0.99", 0.99, false},
+		{"", 0, false},
+		{"twelve", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseImportAmount(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseImportAmount(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseImportAmount(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestImportMappingParse(t *testing.T) {
+	day := func(s string) time.Time {
+		d, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	tests := []struct {
+		name    string
+		mapping ImportMapping
+		file    string
+		want    []ImportRow
+		wantErr string
+	}{
+		{
+			name:    "signed amount column",
+			mapping: ImportMapping{Name: "bank", DateColumn: "Date", AmountColumn: "Amount", DescriptionColumn: "Description", CategoryColumn: "Category"},
+			file: "\ufeffDate,Description,Amount,Category\n" +
+				"2024-01-02,Coffee,-3.50,Food\n" +
+				"2024-01-03,Salary,\"2,000.00\",\n",
+			want: []ImportRow{
+				{Row: 2, Date: day("2024-01-02"), Amount: -3.50, Name: "Coffee", Description: "Coffee", Category: "Food"},
+				{Row: 3, Date: day("2024-01-03"), Amount: 2000, Name: "Salary", Description: "Salary"},
+			},
+		},
+		{
+			name:    "debit and credit columns",
+			mapping: ImportMapping{Name: "bank", DateColumn: "date", DateFormat: "01/02/2006", DebitColumn: "Debit", CreditColumn: "Credit", DescriptionColumn: "Memo", NameColumn: "Payee"},
+			file: "Date,Payee,Memo,Debit,Credit\n" +
+				"01/05/2024,Grocer,weekly shop,45.10,\n" +
+				"01/06/2024,Grocer,refund,,5.00\n" +
+				"01/07/2024,Bank,fee written negative,-2.00,\n",
+			want: []ImportRow{
+				{Row: 2, Date: day("2024-01-05"), Amount: -45.10, Name: "Grocer", Description: "weekly shop"},
+				{Row: 3, Date: day("2024-01-06"), Amount: 5, Name: "Grocer", Description: "refund"},
+				{Row: 4, Date: day("2024-01-07"), Amount: -2, Name: "Bank", Description: "fee written negative"},
+			},
+		},
+		{
+			name:    "parenthesised negatives and semicolons",
+			mapping: ImportMapping{Name: "bank", DateColumn: "Date", AmountColumn: "Amount", DescriptionColumn: "Text", Delimiter: ";"},
+			file: "Date;Text;Amount\n" +
+				"2024-02-01;Rent;(950.00)\n",
+			want: []ImportRow{
+				{Row: 2, Date: day("2024-02-01"), Amount: -950, Name: "Rent", Description: "Rent"},
+			},
+		},
+		{
+			name:    "bad rows are kept with an error",
+			mapping: ImportMapping{Name: "bank", DateColumn: "Date", AmountColumn: "Amount", DescriptionColumn: "Description"},
+			file: "Date,Description,Amount\n" +
+				"02/01/2024,Wrong date,1.00\n" +
+				"2024-01-02,No amount,\n" +
+				"2024-01-03,Bad amount,abc\n",
+			want: []ImportRow{
+				{Row: 2, Name: "Wrong date", Description: "Wrong date", Error: `invalid date "02/01/2024", expected 2006-01-02`},
+				{Row: 3, Date: day("2024-01-02"), Name: "No amount", Description: "No amount", Error: "transaction has no amount"},
+				{Row: 4, Date: day("2024-01-03"), Name: "Bad amount", Description: "Bad amount", Error: `invalid amount "abc"`},
+			},
+		},
+		{
+			name:    "missing column",
+			mapping: ImportMapping{Name: "bank", DateColumn: "Date", AmountColumn: "Value", DescriptionColumn: "Description"},
+			file:    "Date,Description,Amount\n",
+			wantErr: "file has no column Value",
+		},
+		{
+			name:    "empty file",
+			mapping: ImportMapping{Name: "bank", DateColumn: "Date", AmountColumn: "Amount", DescriptionColumn: "Description"},
+			file:    "",
+			wantErr: "file is empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.mapping.validate(); err != nil {
+				t.Fatal(err)
+			}
+			rows, err := tt.mapping.parse(strings.NewReader(tt.file))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("parse error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			compareImportRows(t, rows, tt.want)
+		})
+	}
+}
+
+// compareImportRows reports every difference between the rows read from a
+// file and the rows expected.
+func compareImportRows(t *testing.T, got []ImportRow, want []ImportRow) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d rows, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.Row != w.Row || !g.Date.Equal(w.Date) || g.Amount != w.Amount || g.Name != w.Name ||
+			g.Description != w.Description || g.Category != w.Category || g.Error != w.Error || g.ExternalID != w.ExternalID {
+			t.Errorf("row %d = %+v, want %+v", i, g, w)
+		}
+	}
+}
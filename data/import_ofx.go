@@ -0,0 +1,153 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+var ofxEntities = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&quot;", `"`, "&apos;", "'", "&nbsp;", " ", "&amp;", "&")
+
+// parseOFXDate reads the day of an OFX date, written 20060102 and optionally
+// followed by a time and a time zone which are ignored.
+func parseOFXDate(s string) (time.Time, error) {
+	if len(s) < 8 {
+		return time.Time{}, fmt.Errorf("invalid date %q", s)
+	}
+	d, err := time.Parse("20060102", s[:8])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", s)
+	}
+	return d, nil
+}
+
+// parseOFXAmount reads an OFX amount, which some banks write with a decimal
+// comma.
+func parseOFXAmount(s string) (float32, error) {
+	if strings.Contains(s, ",") && !strings.Contains(s, ".") {
+		s = strings.Replace(s, ",", ".", 1)
+	}
+	return parseImportAmount(s)
+}
+
+// parseOFX reads the transactions and the ledger balance of a bank or credit
+// card statement in an OFX or QFX file. Both the SGML of OFX 1 and the XML of
+// OFX 2 are read: elements are taken as a tag and the text up to the next tag,
+// so closing tags of values are optional.
+func parseOFX(r io.Reader) ([]ImportRow, []BalanceCheck, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	body := string(b)
+	start := strings.Index(strings.ToUpper(body), "<OFX>")
+	if start < 0 {
+		return nil, nil, errors.New("file is not an OFX file")
+	}
+	body = body[start:]
+
+	var rows []ImportRow
+	var checks []BalanceCheck
+	var balance *BalanceCheck
+	current, statements := -1, 0
+
+	for {
+		open := strings.IndexByte(body, '<')
+		if open < 0 {
+			break
+		}
+		end := strings.IndexByte(body[open:], '>')
+		if end < 0 {
+			return nil, nil, errors.New("file has an unterminated tag")
+		}
+		tag := strings.ToUpper(strings.TrimSpace(body[open+1 : open+end]))
+		body = body[open+end+1:]
+		next := strings.IndexByte(body, '<')
+		if next < 0 {
+			next = len(body)
+		}
+		value := ofxEntities.Replace(strings.TrimSpace(body[:next]))
+
+		switch {
+		case tag == "STMTRS" || tag == "CCSTMTRS":
+			statements++
+		case tag == "STMTTRN":
+			rows = append(rows, ImportRow{Row: len(rows) + 1})
+			current = len(rows) - 1
+		case tag == "/STMTTRN":
+			current = -1
+		case tag == "LEDGERBAL":
+			balance = &BalanceCheck{Label: "ledger balance"}
+		case tag == "/LEDGERBAL":
+			if balance != nil && !balance.AsOf.IsZero() {
+				checks = append(checks, *balance)
+			}
+			balance = nil
+		case balance != nil && tag == "BALAMT":
+			amount, err := parseOFXAmount(value)
+			if err != nil {
+				return nil, nil, fmt.Errorf("ledger balance: %w", err)
+			}
+			balance.Statement = amount
+		case balance != nil && tag == "DTASOF":
+			d, err := parseOFXDate(value)
+			if err != nil {
+				return nil, nil, fmt.Errorf("ledger balance: %w", err)
+			}
+			// the ledger balance holds everything posted on that day
+			balance.AsOf = d.AddDate(0, 0, 1)
+		case current >= 0:
+			row := &rows[current]
+			switch tag {
+			case "DTPOSTED":
+				if row.Date, err = parseOFXDate(value); err != nil {
+					row.Error = err.Error()
+				}
+			case "TRNAMT":
+				if row.Amount, err = parseOFXAmount(value); err != nil {
+					row.Error = err.Error()
+				}
+			case "FITID":
+				row.ExternalID = value
+			case "NAME":
+				row.Name = value
+			case "MEMO":
+				row.Description = value
+			}
+		}
+	}
+
+	if statements == 0 {
+		return nil, nil, errors.New("file holds no bank or credit card statement")
+	}
+	if statements > 1 {
+		return nil, nil, fmt.Errorf("file holds %d statements, import them one at a time", statements)
+	}
+	for i := range rows {
+		r := &rows[i]
+		if r.Name == "" {
+			r.Name = r.Description
+		}
+		if r.Description == "" {
+			r.Description = r.Name
+		}
+		if r.Error == "" && r.Date.IsZero() {
+			r.Error = "transaction has no date"
+		}
+	}
+	return rows, checks, nil
+}
+
+// ImportOFX imports the transactions of an OFX or QFX statement. Transactions
+// are matched on their FITID, so the same file or overlapping files can be
+// imported again safely, and the ledger balance of the statement is checked
+// against the balance of the account.
+func (t *Transaction) ImportOFX(username string, account string, file io.Reader, dryRun bool) (ImportResult, error) {
+	rows, checks, err := parseOFX(file)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	return importTransactions(username, account, rows, checks, dryRun)
+}
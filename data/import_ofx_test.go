@@ -0,0 +1,127 @@
+package data
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseOFX(t *testing.T) {
+	day := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name       string
+		file       string
+		want       []ImportRow
+		wantChecks []BalanceCheck
+		wantErr    string
+	}{
+		{
+			name: "SGML without closing tags",
+			file: `OFXHEADER:100
+DATA:OFXSGML
+VERSION:102
+
+<OFX>
+<BANKMSGSRSV1><STMTTRNRS><STMTRS>
+<CURDEF>CAD
+<BANKTRANLIST>
+<STMTTRN>
+<TRNTYPE>DEBIT
+<DTPOSTED>20240105120000[-5:EST]
+<TRNAMT>-45.10
+<FITID>A1
+<NAME>GROCER &amp; SONS
+<MEMO>weekly shop
+</STMTTRN>
+<STMTTRN>
+<TRNTYPE>CREDIT
+<DTPOSTED>20240106
+<TRNAMT>1500,00
+<FITID>A2
+<MEMO>PAYROLL
+</STMTTRN>
+</BANKTRANLIST>
+<LEDGERBAL>
+<BALAMT>2454.90
+<DTASOF>20240131
+</LEDGERBAL>
+</STMTRS></STMTTRNRS></BANKMSGSRSV1>
+</OFX>`,
+			want: []ImportRow{
+				{Row: 1, Date: day(2024, 1, 5), Amount: -45.10, Name: "GROCER & SONS", Description: "weekly shop", ExternalID: "A1"},
+				{Row: 2, Date: day(2024, 1, 6), Amount: 1500, Name: "PAYROLL", Description: "PAYROLL", ExternalID: "A2"},
+			},
+			wantChecks: []BalanceCheck{
+				{Label: "ledger balance", AsOf: day(2024, 2, 1), Statement: 2454.90},
+			},
+		},
+		{
+			name: "XML with closing tags",
+			file: `<?xml version="1.0"?>
+<OFX><CREDITCARDMSGSRSV1><CCSTMTTRNRS><CCSTMTRS>
+<BANKTRANLIST>
+<STMTTRN><DTPOSTED>20240301</DTPOSTED><TRNAMT>-9.99</TRNAMT><FITID>C1</FITID><NAME>STREAMING</NAME></STMTTRN>
+</BANKTRANLIST>
+</CCSTMTRS></CCSTMTTRNRS></CREDITCARDMSGSRSV1></OFX>`,
+			want: []ImportRow{
+				{Row: 1, Date: day(2024, 3, 1), Amount: -9.99, Name: "STREAMING", Description: "STREAMING", ExternalID: "C1"},
+			},
+		},
+		{
+			name: "rows that can not be read",
+			file: `<OFX><STMTRS>
+<STMTTRN><DTPOSTED>2024<TRNAMT>-1.00<FITID>B1<NAME>BAD DATE</STMTTRN>
+<STMTTRN><TRNAMT>-2.00<FITID>B2<NAME>NO DATE</STMTTRN>
+</STMTRS></OFX>`,
+			want: []ImportRow{
+				{Row: 1, Amount: -1, Name: "BAD DATE", Description: "BAD DATE", ExternalID: "B1", Error: `invalid date "2024"`},
+				{Row: 2, Amount: -2, Name: "NO DATE", Description: "NO DATE", ExternalID: "B2", Error: "transaction has no date"},
+			},
+		},
+		{
+			name:    "not an OFX file",
+			file:    "Date,Amount\n",
+			wantErr: "file is not an OFX file",
+		},
+		{
+			name:    "two statements",
+			file:    "<OFX><STMTRS></STMTRS><STMTRS></STMTRS></OFX>",
+			wantErr: "file holds 2 statements, import them one at a time",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, checks, err := parseOFX(strings.NewReader(tt.file))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("parseOFX error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			compareImportRows(t, rows, tt.want)
+			compareBalanceChecks(t, checks, tt.wantChecks)
+		})
+	}
+}
+
+// compareBalanceChecks compares the balances given by a statement, leaving
+// out the ones only known after the import.
+func compareBalanceChecks(t *testing.T, got []BalanceCheck, want []BalanceCheck) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d balance checks, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.Label != w.Label || !g.AsOf.Equal(w.AsOf) || g.Statement != w.Statement {
+			t.Errorf("balance check %d = %+v, want %+v", i, g, w)
+		}
+	}
+}
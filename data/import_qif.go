@@ -0,0 +1,159 @@
+package data
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// qifTypes are the QIF sections holding transactions of a bank account.
+var qifTypes = map[string]bool{"bank": true, "cash": true, "ccard": true, "oth a": true, "oth l": true}
+
+// parseQIFDate reads the dates written by personal finance programs, such as
+// 1/25/2024, 01/25'24, 1/25/24 or 2024-01-25. A year written with two digits
+// after an apostrophe is in the 2000s. dayFirst reads 25/01/2024.
+func parseQIFDate(s string, dayFirst bool) (time.Time, error) {
+	s = strings.ReplaceAll(s, " ", "")
+	if d, err := time.Parse("2006-01-02", s); err == nil {
+		return d, nil
+	}
+
+	parts := strings.FieldsFunc(s, func(r rune) bool {
+		return r == '/' || r == '\'' || r == '-' || r == '.'
+	})
+	if len(parts) != 3 {
+		return time.Time{}, fmt.Errorf("invalid date %q", s)
+	}
+	var n [3]int
+	for i, p := range parts {
+		v, err := strconv.Atoi(p)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid date %q", s)
+		}
+		n[i] = v
+	}
+	month, day, year := n[0], n[1], n[2]
+	if dayFirst {
+		month, day = day, month
+	}
+	if len(parts[2]) <= 2 {
+		if strings.Contains(s, "'") || year < 70 {
+			year += 2000
+		} else {
+			year += 1900
+		}
+	}
+
+	d := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	if d.Month() != time.Month(month) || d.Day() != day {
+		return time.Time{}, fmt.Errorf("invalid date %q", s)
+	}
+	return d, nil
+}
+
+// parseQIF reads the transactions of the bank account sections of a QIF file.
+// Transfers, written as a category in brackets, are imported without a
+// category and the class after a slash is dropped. Splits are not read.
+func parseQIF(r io.Reader, dayFirst bool) ([]ImportRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var rows []ImportRow
+	var row ImportRow
+	var date string
+	inBank, started, pending := false, false, false
+
+	finish := func() {
+		if !pending {
+			return
+		}
+		row.Row = len(rows) + 1
+		if row.Name == "" {
+			row.Name = row.Description
+		}
+		if row.Description == "" {
+			row.Description = row.Name
+		}
+		if row.Error == "" {
+			var err error
+			if date == "" {
+				row.Error = "transaction has no date"
+			} else if row.Date, err = parseQIFDate(date, dayFirst); err != nil {
+				row.Error = err.Error()
+			}
+		}
+		rows = append(rows, row)
+		row, date, pending = ImportRow{}, "", false
+	}
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "!") {
+			finish()
+			header := strings.ToLower(strings.TrimSpace(line))
+			if strings.HasPrefix(header, "!type:") {
+				inBank = qifTypes[strings.TrimPrefix(header, "!type:")]
+				started = started || inBank
+			} else if !strings.HasPrefix(header, "!option") && !strings.HasPrefix(header, "!clear") {
+				inBank = false
+			}
+			continue
+		}
+		if !inBank {
+			continue
+		}
+
+		value := strings.TrimSpace(line[1:])
+		switch line[0] {
+		case '^':
+			finish()
+			continue
+		case 'D':
+			date = value
+		case 'T', 'U':
+			amount, err := parseImportAmount(value)
+			if err != nil {
+				row.Error = err.Error()
+			}
+			row.Amount = amount
+		case 'P':
+			row.Name = value
+		case 'M':
+			row.Description = value
+		case 'L':
+			if i := strings.IndexByte(value, '/'); i >= 0 {
+				value = value[:i]
+			}
+			if !strings.HasPrefix(value, "[") {
+				row.Category = value
+			}
+		}
+		pending = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	finish()
+
+	if !started {
+		return nil, errors.New("file holds no bank, cash or credit card account")
+	}
+	return rows, nil
+}
+
+// ImportQIF imports the transactions of a QIF file. QIF files carry no ids
+// and no balances, so duplicates are found by day, amount and payee.
+func (t *Transaction) ImportQIF(username string, account string, file io.Reader, dayFirst bool, dryRun bool) (ImportResult, error) {
+	rows, err := parseQIF(file, dayFirst)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	return importTransactions(username, account, rows, nil, dryRun)
+}
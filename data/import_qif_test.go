@@ -0,0 +1,113 @@
+package data
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseQIFDate(t *testing.T) {
+	tests := []struct {
+		in       string
+		dayFirst bool
+		want     string
+		wantErr  bool
+	}{
+		{"1/25/2024", false, "2024-01-25", false},
+		{"01/25'24", false, "2024-01-25", false},
+		{"1/25' 4", false, "2004-01-25", false},
+		{"1/25/24", false, "2024-01-25", false},
+		{"1/25/99", false, "1999-01-25", false},
+		{"12/31'99", false, "2099-12-31", false},
+		{"2024-01-25", false, "2024-01-25", false},
+		{"25/01/2024", true, "2024-01-25", false},
+		{"25.01'24", true, "2024-01-25", false},
+		{"25/01/2024", false, "", true},
+		{"2/30/2024", false, "", true},
+		{"yesterday", false, "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseQIFDate(tt.in, tt.dayFirst)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseQIFDate(%q, %v) error = %v, wantErr %v", tt.in, tt.dayFirst, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got.Format("2006-01-02") != tt.want {
+			t.Errorf("parseQIFDate(%q, %v) = %s, want %s", tt.in, tt.dayFirst, got.Format("2006-01-02"), tt.want)
+		}
+	}
+}
+
+func TestParseQIF(t *testing.T) {
+	day := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name     string
+		file     string
+		dayFirst bool
+		want     []ImportRow
+		wantErr  string
+	}{
+		{
+			name: "bank account",
+			file: "!Type:Bank\r\n" +
+				"D1/05'24\r\nT-1,045.10\r\nPGrocer\r\nMweekly shop\r\nLFood/Home\r\n^\r\n" +
+				"D1/06'24\r\nU1500.00\r\nPEmployer\r\nL[Savings]\r\n^\r\n" +
+				"D01/07/2024\r\nT(12.00)\r\nMfee\r\n^\r\n",
+			want: []ImportRow{
+				{Row: 1, Date: day(2024, 1, 5), Amount: -1045.10, Name: "Grocer", Description: "weekly shop", Category: "Food"},
+				{Row: 2, Date: day(2024, 1, 6), Amount: 1500, Name: "Employer", Description: "Employer"},
+				{Row: 3, Date: day(2024, 1, 7), Amount: -12, Name: "fee", Description: "fee"},
+			},
+		},
+		{
+			name:     "day first",
+			file:     "!Type:CCard\nD25/01/2024\nT-3.50\nPCafe\n^\n",
+			dayFirst: true,
+			want: []ImportRow{
+				{Row: 1, Date: day(2024, 1, 25), Amount: -3.50, Name: "Cafe", Description: "Cafe"},
+			},
+		},
+		{
+			name: "other sections are skipped",
+			file: "!Option:AutoSwitch\n!Account\nNChequing\nTBank\n^\n!Clear:AutoSwitch\n" +
+				"!Type:Invst\nD1/01'24\nT-100.00\nPBroker\n^\n" +
+				"!Type:Bank\nD1/02'24\nT-1.00\nPKiosk\n^\n",
+			want: []ImportRow{
+				{Row: 1, Date: day(2024, 1, 2), Amount: -1, Name: "Kiosk", Description: "Kiosk"},
+			},
+		},
+		{
+			name: "rows that can not be read",
+			file: "!Type:Bank\nT-1.00\nPNo date\n^\nD13/45/2024\nT-2.00\nPBad date\n^\nD1/03'24\nTabc\nPBad amount\n^\n",
+			want: []ImportRow{
+				{Row: 1, Amount: -1, Name: "No date", Description: "No date", Error: "transaction has no date"},
+				{Row: 2, Amount: -2, Name: "Bad date", Description: "Bad date", Error: `invalid date "13/45/2024"`},
+				{Row: 3, Name: "Bad amount", Description: "Bad amount", Error: `invalid amount "abc"`},
+			},
+		},
+		{
+			name:    "no bank account",
+			file:    "!Type:Invst\nD1/01'24\nT-100.00\n^\n",
+			wantErr: "file holds no bank, cash or credit card account",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := parseQIF(strings.NewReader(tt.file), tt.dayFirst)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("parseQIF error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			compareImportRows(t, rows, tt.want)
+		})
+	}
+}
@@ -0,0 +1,360 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// maxImportRows bounds the number of transactions in one import.
+const maxImportRows = 50000
+
+// importBatchSize is the number of rows inserted by one statement.
+const importBatchSize = 1000
+
+// ErrImportRejected is returned when an import is committed while some of its
+// rows can not be imported. Nothing is imported then.
+var ErrImportRejected = errors.New("import has rows that can not be imported, nothing was imported")
+
+// ImportRow is one transaction read from an imported file.
+type ImportRow struct {
+	Row           int       `json:"row"`
+	Date          time.Time `json:"date"`
+	Amount        float32   `json:"amount"`
+	Name          string    `json:"name"`
+	Description   string    `json:"description"`
+	Category      string    `json:"category"`
+	Tags          []string  `json:"tags,omitempty"`
+	Duplicate     bool      `json:"duplicate"`
+	Error         string    `json:"error,omitempty"`
+	Warning       string    `json:"warning,omitempty"`
+	ExternalID    string    `json:"externalID,omitempty"`
+	PayeeID       *int      `json:"payeeID,omitempty"`
+	TransactionID int       `json:"transactionID,omitempty"`
+}
+
+// BalanceCheck compares a balance given by an imported statement with the
+// balance of the account from the transactions dated before AsOf, once the
+// import is done.
+type BalanceCheck struct {
+	Label      string    `json:"label"`
+	AsOf       time.Time `json:"asOf"`
+	Statement  float32   `json:"statement"`
+	Computed   float32   `json:"computed"`
+	Difference float32   `json:"difference"`
+	Matches    bool      `json:"matches"`
+}
+
+// ImportResult is what an import did, or would do on a dry run.
+type ImportResult struct {
+	DryRun     bool           `json:"dryRun"`
+	Total      int            `json:"total"`
+	Imported   int            `json:"imported"`
+	Duplicates int            `json:"duplicates"`
+	Failed     int            `json:"failed"`
+	Warnings   int            `json:"warnings"`
+	Balances   []BalanceCheck `json:"balances,omitempty"`
+	Rows       []ImportRow    `json:"rows"`
+}
+
+// dedupKey identifies a transaction by its day, amount in cents and payee.
+func dedupKey(date time.Time, amount float32, name string) string {
+	return fmt.Sprintf("%s|%d|%s", date.Format("2006-01-02"), int64(math.Round(float64(amount)*100)), strings.ToLower(strings.TrimSpace(name)))
+}
+
+// importTransactions runs rows through the rules of the account, checks their
+// categories and marks the ones already on the account as duplicates. Unless
+// dryRun is set, the other rows are posted as historical transactions, all
+// of them or none when a row has an error.
+//
+// A category the account does not have is dropped with a warning, leaving the
+// row to the rules and payees, so that the files of a bank can be imported
+// before the categories are set up.
+//
+// A row with an ExternalID is a duplicate when a transaction with the same id
+// exists, which makes imports of overlapping bank files safe to repeat. Other
+// rows are duplicates when a transaction with the same day, amount and payee
+// exists. Each existing transaction only covers one row, so repeated
+// purchases on one day are kept.
+//
+// The balances of checks are worked out as they would be after the import and
+// returned with the result. A mismatch does not stop the import.
+//
+// Rows are inserted in batches under a single lock of the account, so large
+// files fit the deadline of one request.
+func importTransactions(username string, account string, rows []ImportRow, checks []BalanceCheck, dryRun bool) (ImportResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout*10)
+	defer cancel()
+
+	result := ImportResult{DryRun: dryRun, Total: len(rows), Rows: rows}
+	if len(rows) > maxImportRows {
+		return result, fmt.Errorf("an import can hold at most %d transactions", maxImportRows)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1 || '/' || $2))`, username, account)
+	if err != nil {
+		return result, err
+	}
+	if err := checkWritable(ctx, tx, username, account); err != nil {
+		return result, err
+	}
+	rules, err := getRules(ctx, tx, username, account)
+	if err != nil {
+		return result, err
+	}
+	payees, err := getPayees(ctx, tx, username, account)
+	if err != nil {
+		return result, err
+	}
+
+	categories := map[string]bool{}
+	dropUnknownCategory := func(r *ImportRow) error {
+		known, ok := categories[r.Category]
+		if !ok {
+			err := validateCategory(ctx, tx, username, account, r.Category)
+			if err != nil && !errors.Is(err, ErrUnknownCategory) {
+				return err
+			}
+			known = err == nil
+			categories[r.Category] = known
+		}
+		if !known {
+			r.Warning = fmt.Sprintf("category %s does not exist, imported without a category", r.Category)
+			r.Category = ""
+		}
+		return nil
+	}
+
+	var first, last time.Time
+	for i := range rows {
+		r := &rows[i]
+		if r.Error != "" {
+			continue
+		}
+		if err := dropUnknownCategory(r); err != nil {
+			return result, err
+		}
+		r.Name, r.Category, r.Tags = applyRules(rules, r.Name, r.Description, r.Category, r.Amount, false)
+		r.PayeeID, r.Category = applyPayees(payees, r.Name, r.Category)
+		if err := dropUnknownCategory(r); err != nil {
+			return result, err
+		}
+		if r.Warning != "" {
+			result.Warnings++
+		}
+
+		if first.IsZero() || r.Date.Before(first) {
+			first = r.Date
+		}
+		if r.Date.After(last) {
+			last = r.Date
+		}
+	}
+
+	var externalIDs []string
+	for _, r := range rows {
+		if r.Error == "" && r.ExternalID != "" {
+			externalIDs = append(externalIDs, r.ExternalID)
+		}
+	}
+	knownIDs := map[string]bool{}
+	if len(externalIDs) > 0 {
+		query := `SELECT externalid FROM mrkrabs.Transactions
+		WHERE username = $1 AND accountname = $2 AND externalid = ANY($3)`
+
+		dbRows, err := tx.QueryContext(ctx, query, username, account, externalIDs)
+		if err != nil {
+			return result, err
+		}
+		for dbRows.Next() {
+			var id string
+			if err := dbRows.Scan(&id); err != nil {
+				dbRows.Close()
+				return result, err
+			}
+			knownIDs[id] = true
+		}
+		dbRows.Close()
+		if err := dbRows.Err(); err != nil {
+			return result, err
+		}
+	}
+
+	existing := map[string]int{}
+	if !first.IsZero() {
+		query := `SELECT transactiondate, transactionamount, transactionname FROM mrkrabs.Transactions
+		WHERE username = $1 AND accountname = $2 AND transactiondate >= $3 AND transactiondate < $4`
+
+		dbRows, err := tx.QueryContext(ctx, query, username, account, first, last.AddDate(0, 0, 1))
+		if err != nil {
+			return result, err
+		}
+		for dbRows.Next() {
+			var date time.Time
+			var amount float32
+			var name string
+			if err := dbRows.Scan(&date, &amount, &name); err != nil {
+				dbRows.Close()
+				return result, err
+			}
+			existing[dedupKey(date, amount, name)]++
+		}
+		dbRows.Close()
+		if err := dbRows.Err(); err != nil {
+			return result, err
+		}
+	}
+
+	for i := range rows {
+		r := &rows[i]
+		switch {
+		case r.Error != "":
+			result.Failed++
+		case r.ExternalID != "":
+			// a file may also repeat an id, which counts as a duplicate too
+			if knownIDs[r.ExternalID] {
+				r.Duplicate = true
+				result.Duplicates++
+			}
+			knownIDs[r.ExternalID] = true
+		case existing[dedupKey(r.Date, r.Amount, r.Name)] > 0:
+			existing[dedupKey(r.Date, r.Amount, r.Name)]--
+			r.Duplicate = true
+			result.Duplicates++
+		}
+	}
+
+	if dryRun {
+		result.Imported = result.Total - result.Duplicates - result.Failed
+		result.Balances, err = checkImportBalances(ctx, tx, username, account, rows, checks, true)
+		return result, err
+	}
+	if result.Failed > 0 {
+		return result, ErrImportRejected
+	}
+
+	var pending []*ImportRow
+	for i := range rows {
+		if !rows[i].Duplicate {
+			pending = append(pending, &rows[i])
+		}
+	}
+	for len(pending) > 0 {
+		batch := pending
+		if len(batch) > importBatchSize {
+			batch = batch[:importBatchSize]
+		}
+		if err := insertImportRows(ctx, tx, username, account, batch); err != nil {
+			return result, err
+		}
+		result.Imported += len(batch)
+		pending = pending[len(batch):]
+	}
+
+	result.Balances, err = checkImportBalances(ctx, tx, username, account, rows, checks, false)
+	if err != nil {
+		return result, err
+	}
+	return result, tx.Commit()
+}
+
+// insertImportRows inserts rows as historical transactions and sets their
+// TransactionID. It skips the balance rules of postTransaction, which do not
+// apply to imported history; the caller holds the lock of the account and
+// has checked that it can be written.
+func insertImportRows(ctx context.Context, tx *sql.Tx, username string, account string, rows []*ImportRow) error {
+	amounts := make([]float32, len(rows))
+	names := make([]string, len(rows))
+	descriptions := make([]string, len(rows))
+	categories := make([]string, len(rows))
+	dates := make([]time.Time, len(rows))
+	externalIDs := make([]string, len(rows))
+	payeeIDs := make([]*int, len(rows))
+	for i, r := range rows {
+		amounts[i], names[i], descriptions[i], categories[i] = r.Amount, r.Name, r.Description, r.Category
+		dates[i], externalIDs[i], payeeIDs[i] = r.Date, r.ExternalID, r.PayeeID
+	}
+
+	// ids are handed out in the order of the select, so sorting them gives
+	// the rows back in order
+	query := `WITH inserted AS (
+		INSERT INTO mrkrabs.Transactions (Username, AccountName, TransactionAmount, TransactionName, TransactionDescription, Category, TransactionDate, ExternalID, PayeeID)
+		SELECT $1, $2, v.amount, v.name, v.description, v.category, v.date, NULLIF(v.externalid, ''), v.payeeid
+		FROM unnest($3::real[], $4::text[], $5::text[], $6::text[], $7::timestamptz[], $8::text[], $9::int[])
+			WITH ORDINALITY AS v(amount, name, description, category, date, externalid, payeeid, n)
+		ORDER BY v.n
+		RETURNING TransactionID
+	)
+	SELECT TransactionID FROM inserted ORDER BY TransactionID`
+
+	dbRows, err := tx.QueryContext(ctx, query, username, account, amounts, names, descriptions, categories, dates, externalIDs, payeeIDs)
+	if err != nil {
+		return err
+	}
+	i := 0
+	for i < len(rows) && dbRows.Next() {
+		if err := dbRows.Scan(&rows[i].TransactionID); err != nil {
+			dbRows.Close()
+			return err
+		}
+		i++
+	}
+	dbRows.Close()
+	if err := dbRows.Err(); err != nil {
+		return err
+	}
+	if i != len(rows) {
+		return fmt.Errorf("inserted %d of %d rows", i, len(rows))
+	}
+
+	var tagged []int
+	var tags []string
+	for _, r := range rows {
+		for _, tag := range r.Tags {
+			tagged = append(tagged, r.TransactionID)
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	query = `INSERT INTO mrkrabs.TransactionTag (transactionid, tag)
+	SELECT * FROM unnest($1::int[], $2::text[])
+	ON CONFLICT DO NOTHING`
+	_, err = tx.ExecContext(ctx, query, tagged, tags)
+	return err
+}
+
+// checkImportBalances fills in the computed balances of checks. On a dry run
+// the rows that would be imported are added to the balances of the account.
+func checkImportBalances(ctx context.Context, tx *sql.Tx, username string, account string, rows []ImportRow, checks []BalanceCheck, dryRun bool) ([]BalanceCheck, error) {
+	query := `SELECT COALESCE(SUM(TransactionAmount), 0) FROM mrkrabs.Transactions
+	WHERE Username = $1 AND accountname = $2 AND transactiondate < $3`
+
+	for i := range checks {
+		c := &checks[i]
+		if err := tx.QueryRowContext(ctx, query, username, account, c.AsOf).Scan(&c.Computed); err != nil {
+			return checks, err
+		}
+		if dryRun {
+			for _, r := range rows {
+				if r.Error == "" && !r.Duplicate && r.Date.Before(c.AsOf) {
+					c.Computed += r.Amount
+				}
+			}
+		}
+		c.Difference = c.Statement - c.Computed
+		c.Matches = math.Round(float64(c.Difference)*100) == 0
+	}
+	return checks, nil
+}
@@ -3,13 +3,15 @@ package data
 import (
 	"context"
 	"database/sql"
-	"errors"
+	"encoding/json"
 	"fmt"
 	"log"
 
 	// "errors"
 
 	"time"
+
+	"github.com/jackc/pgtype"
 )
 
 const dbTimeout = time.Second * 3
@@ -31,15 +33,32 @@ type Models struct {
 	Account          Account
 	Category         Category
 	Debt             Debt
+	APIKey           APIKey
+	Rule             Rule
+	Budget           Budget
+	Goal             Goal
+	Asset            Asset
+	ImportMapping    ImportMapping
+	Reconciliation   Reconciliation
+	Attachment       Attachment
+	Payee            Payee
 }
 
 type Transaction struct {
-	TransactionID          int     `json:"transaction_id"`
-	UserID                 string  `json:"user_id"`
-	TransactionAmount      float32 `json:"transactionAmount"`
-	TransactionName        string  `json:"transactionName"`
-	TransactionDescription string  `json:"transactionDescription"`
-	TransactionCategory    string  `json:"transactionCategory"`
+	TransactionID          int       `json:"transaction_id"`
+	UserID                 string    `json:"user_id"`
+	TransactionAmount      float32   `json:"transactionAmount"`
+	TransactionName        string    `json:"transactionName"`
+	TransactionDescription string    `json:"transactionDescription"`
+	TransactionCategory    string    `json:"transactionCategory"`
+	TransactionDate        time.Time `json:"transactionDate"`
+	RunningBalance         *float32  `json:"running_balance,omitempty"`
+	Flags                  []string  `json:"flags,omitempty"`
+	ClearedStatus          string    `json:"clearedStatus,omitempty"`
+	Splits                 []Split   `json:"splits,omitempty"`
+	Tags                   []string  `json:"tags,omitempty"`
+	PayeeID                *int      `json:"payeeID,omitempty"`
+	Payee                  string    `json:"payee,omitempty"`
 }
 
 type Debt struct {
@@ -55,15 +74,29 @@ type DebtPayment struct {
 }
 
 type Category struct {
+	CategoryID          int    `json:"categoryID"`
 	TransactionCategory string `json:"transactionCategory"`
 	Username            string `json:"username"`
+	AccountName         string `json:"accountname"`
+	Colour              string `json:"colour"`
+	Icon                string `json:"icon"`
+	ParentID            *int   `json:"parentID,omitempty"`
 }
 
 type Account struct {
-	AccountID   int    `json:"id"`
-	AccountName string `json:"accountname"`
-	Email       string `json:"email"`
-	IsPrimary   bool   `json:"isprimary"`
+	AccountID              int           `json:"id"`
+	AccountName            string        `json:"accountname"`
+	Email                  string        `json:"email"`
+	IsPrimary              bool          `json:"isprimary"`
+	AccountType            string        `json:"accountType"`
+	CreditLimit            float32       `json:"creditLimit"`
+	MonthlyWithdrawalLimit int           `json:"monthlyWithdrawalLimit"`
+	Policy                 BalancePolicy `json:"policy"`
+	Status                 string        `json:"status"`
+	StatusChangedAt        *time.Time    `json:"statusChangedAt,omitempty"`
+	PreviousName           *string       `json:"previousName,omitempty"`
+	RenamedAt              *time.Time    `json:"renamedAt,omitempty"`
+	EnvelopeMode           bool          `json:"envelopeMode"`
 }
 
 type RecurringPayment struct {
@@ -76,6 +109,7 @@ type RecurringPayment struct {
 	PaymentType        string  `json:"paymentType"`
 	PaymentFrequency   string  `json:"paymentFrequency"`
 	NextPaymentDate    string  `json:"nextPaymentDate"`
+	PaymentCategory    *string `json:"paymentCategory,omitempty"`
 }
 
 type PaymentHistory struct {
@@ -85,13 +119,15 @@ type PaymentHistory struct {
 	PaymentHistoryStatus bool   `json:"paymentHistoryStatus"`
 }
 
-func (t *Account) GetUserAccounts(email string) ([]Account, error) {
+// GetUserAccounts returns the accounts of a user. Archived and closed
+// accounts are only included when includeInactive is set.
+func (t *Account) GetUserAccounts(email string, includeInactive bool) ([]Account, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
-	query := `SELECT *
-				FROM mrkrabs.Account WHERE username = $1`
+	query := `SELECT ` + accountColumns + `
+				FROM mrkrabs.Account WHERE username = $1 AND ($2 OR status = 'open')`
 
-	rows, err := db.QueryContext(ctx, query, email)
+	rows, err := db.QueryContext(ctx, query, email, includeInactive)
 	if err != nil {
 		return nil, err
 	}
@@ -100,8 +136,8 @@ func (t *Account) GetUserAccounts(email string) ([]Account, error) {
 	var accounts []Account
 
 	for rows.Next() {
-		var account Account
-		if err := rows.Scan(&account.AccountID, &account.AccountName, &account.Email, &account.IsPrimary); err != nil {
+		account, err := scanAccount(rows)
+		if err != nil {
 			return accounts, err
 		}
 		accounts = append(accounts, account)
@@ -112,14 +148,22 @@ func (t *Account) GetUserAccounts(email string) ([]Account, error) {
 	return accounts, nil
 }
 
-func (t *Account) AddAccount(email string, account_name string) (float32, error) {
+func (t *Account) AddAccount(email string, account_name string, accountType string, creditLimit float32, monthlyWithdrawalLimit int) (float32, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
+
+	if accountType == "" {
+		accountType = AccountTypeChequing
+	}
+	if err := validateAccountType(accountType, creditLimit, monthlyWithdrawalLimit); err != nil {
+		return 0, err
+	}
+
 	query := `INSERT INTO mrkrabs.Account (
-		accountname, username, isprimary)
-		VALUES ($1, $2, $3);`
+		accountname, username, isprimary, accounttype, creditlimit, monthlywithdrawallimit)
+		VALUES ($1, $2, $3, $4, $5, $6);`
 
-	_, err := db.ExecContext(ctx, query, account_name, email, true)
+	_, err := db.ExecContext(ctx, query, account_name, email, true, accountType, creditLimit, monthlyWithdrawalLimit)
 
 	if err != nil {
 		return 0, err
@@ -128,22 +172,48 @@ func (t *Account) AddAccount(email string, account_name string) (float32, error)
 	return 1, err
 }
 
-func (t *Account) AddUserToAccount(email string, account_name string) (float32, error) {
+// AddUserToAccount adds a user to the account of member. The row of the new
+// member copies the type, limits and balance policy of the account from the
+// row of member, so that their transactions are held to the same rules.
+func (t *Account) AddUserToAccount(member string, email string, account_name string) (float32, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 	query := `INSERT INTO mrkrabs.Account (
-		accountname, username, isprimary)
-		VALUES ($1, $2, $3);`
-
-	_, err := db.ExecContext(ctx, query, account_name, email, false)
+		accountname, username, isprimary, accounttype, creditlimit, monthlywithdrawallimit,
+		minimumbalance, overdraftlimit, overdraftfee, recurringmayoverdraw)
+		SELECT accountname, $1, $2, accounttype, creditlimit, monthlywithdrawallimit,
+		minimumbalance, overdraftlimit, overdraftfee, recurringmayoverdraw
+		FROM mrkrabs.Account WHERE username = $3 AND accountname = $4;`
 
+	res, err := db.ExecContext(ctx, query, email, false, member, account_name)
 	if err != nil {
 		return 0, err
 	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("account %s not found", account_name)
+	}
 
 	return 1, err
 }
 
+func (t *Account) IsMember(email string, account_name string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	query := `SELECT EXISTS (
+		SELECT 1 FROM mrkrabs.Account WHERE username = $1 AND accountname = $2)`
+
+	var member bool
+	err := db.QueryRowContext(ctx, query, email, account_name).Scan(&member)
+	if err != nil {
+		return false, err
+	}
+	return member, nil
+}
+
 func (t *Transaction) GetUserBalance(email string, account string) (float32, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
@@ -169,9 +239,18 @@ func (t *Transaction) UpdateTransactionCategory(username string, account string,
 	query := `
 	update mrkrabs.transactions
 	set category = $1
-	where transactionid = $2 and username = $3 and accountname = $3
+	where transactionid = $2 and username = $3 and accountname = $4
 	`
-	_, err := db.QueryContext(ctx, query, category, transactionID, username, account)
+	if err := checkWritable(ctx, db, username, account); err != nil {
+		return err
+	}
+	if err := checkNotReconciled(ctx, db, username, account, transactionID); err != nil {
+		return err
+	}
+	if err := validateCategory(ctx, db, username, account, category); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, query, category, transactionID, username, account)
 	if err != nil {
 		return err
 	}
@@ -179,56 +258,107 @@ func (t *Transaction) UpdateTransactionCategory(username string, account string,
 }
 
 func (t *Transaction) GetAllCategories(username string, account string) ([]string, error) {
+	var c Category
+	categories, err := c.GetCategories(username, account)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, category := range categories {
+		names = append(names, category.TransactionCategory)
+	}
+	return names, nil
+}
+
+// UpdateBalance posts a transaction to an account and returns the new
+// balance. Recurring marks payments made on behalf of a recurring payment. On
+// accounts in envelope mode, envelopes split a deposit over the envelopes it
+// is assigned to.
+func (t *Transaction) UpdateBalance(username string, account string, transactionAmount float32, transactionName string, transactionDescription string, transactionCategory string, recurring bool, envelopes map[string]float32) (float32, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
-	query := `
-	select distinct category from mrkrabs.transactions
-  where username = $1 and accountname = $2
-	`
 
-	var categories []string
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
 
-	rows, err := db.QueryContext(ctx, query, username, account)
-	for rows.Next() {
-		var s string
-		if err := rows.Scan(&s); err != nil {
-			return categories, nil
-		}
-		categories = append(categories, s)
+	rules, err := getRules(ctx, tx, username, account)
+	if err != nil {
+		return 0, err
 	}
+	transactionName, transactionCategory, tags := applyRules(rules, transactionName, transactionDescription, transactionCategory, transactionAmount, false)
+	payees, err := getPayees(ctx, tx, username, account)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	return categories, nil
-}
+	payeeID, transactionCategory := applyPayees(payees, transactionName, transactionCategory)
 
-func (t *Transaction) UpdateBalance(username string, account string, transactionAmount float32, transactionName string, transactionDescription string, transactionCategory string) (float32, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
-	query := `insert into mrkrabs.Transactions (Username, AccountName, TransactionAmount, TransactionName, TransactionDescription, Category) values
-	($1,$2,$3,$4,$5,$6)`
+	if err := validateCategory(ctx, tx, username, account, transactionCategory); err != nil {
+		return 0, err
+	}
 
-	balance, err := t.GetUserBalance(username, account)
-	if err != nil && err.Error() != "sql: no rows in result set" {
+	acct, err := accountOrDefault(ctx, tx, username, account)
+	if err != nil {
 		return 0, err
 	}
-	if (balance + transactionAmount) < 0 {
-		return 0, errors.New("error. can not decrement balance below zero")
+	if err := acct.checkEnvelopes(transactionAmount, transactionCategory, envelopes); err != nil {
+		return 0, err
 	}
 
-	_, err = db.ExecContext(ctx, query, username, account, transactionAmount, transactionName, transactionDescription, transactionCategory)
+	pending := pendingTransaction{
+		Username:    username,
+		Account:     account,
+		Amount:      transactionAmount,
+		Name:        transactionName,
+		Description: transactionDescription,
+		Category:    transactionCategory,
+		Tags:        tags,
+		Recurring:   recurring,
+		PayeeID:     payeeID,
+	}
+	transactionID, balance, err := postTransaction(ctx, tx, pending)
 	if err != nil {
 		return 0, err
 	}
+	if err := flagTransaction(ctx, tx, pending, transactionID); err != nil {
+		return 0, err
+	}
 
-	return balance + transactionAmount, nil
+	if acct.EnvelopeMode && transactionAmount > 0 {
+		if err := assignEnvelopes(ctx, tx, username, account, envelopes, &transactionID); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return balance, nil
 }
-func (t *Transaction) GetAllTransactionsOfCategory(username, account string, category string) ([]Transaction, error) {
+
+// GetAllTransactionsOfCategory returns the transactions of a category, and of
+// all categories below it when includeDescendants is set. A split transaction
+// is returned once for each of its lines in those categories, with the amount
+// of the line.
+func (t *Transaction) GetAllTransactionsOfCategory(username, account string, category string, includeDescendants bool) ([]Transaction, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
-	query := `select TransactionID, username, transactionamount, transactionname, transactiondescription, category from mrkrabs.Transactions where Username = $1 and category = $2 and accountname = $3`
-
-	rows, err := db.QueryContext(ctx, query, username, category, account)
+	query := `
+	with recursive tree as (
+		select categoryid, name from mrkrabs.Category where username = $1 and accountname = $3 and name = $2
+		union all
+		select c.categoryid, c.name from mrkrabs.Category c join tree on c.parentid = tree.categoryid where $4
+	)
+	select TransactionID, username, transactionamount, transactionname, transactiondescription, category, transactiondate, clearedstatus,
+		` + transactionFlagsColumn + `
+	from ` + categoryLines + ` t
+	where Username = $1 and accountname = $3 and (category = $2 or category in (select name from tree))
+	order by transactiondate, TransactionID`
+
+	rows, err := db.QueryContext(ctx, query, username, category, account, includeDescendants)
 	if err != nil {
 		return nil, err
 	}
@@ -237,7 +367,11 @@ func (t *Transaction) GetAllTransactionsOfCategory(username, account string, cat
 	var transactions []Transaction
 	for rows.Next() {
 		var trans Transaction
-		if err := rows.Scan(&trans.TransactionID, &trans.UserID, &trans.TransactionAmount, &trans.TransactionName, &trans.TransactionDescription, &trans.TransactionCategory); err != nil {
+		var flags pgtype.TextArray
+		if err := rows.Scan(&trans.TransactionID, &trans.UserID, &trans.TransactionAmount, &trans.TransactionName, &trans.TransactionDescription, &trans.TransactionCategory, &trans.TransactionDate, &trans.ClearedStatus, &flags); err != nil {
+			return transactions, err
+		}
+		if err := flags.AssignTo(&trans.Flags); err != nil {
 			return transactions, err
 		}
 		transactions = append(transactions, trans)
@@ -247,12 +381,36 @@ func (t *Transaction) GetAllTransactionsOfCategory(username, account string, cat
 	}
 	return transactions, nil
 }
-func (t *Transaction) GetAllTransactions(username string, account string) ([]Transaction, error) {
+
+// GetAllTransactions returns the transactions of an account, each with the
+// balance of the account right after it, its split lines, its tags and its
+// payee. When tags are given only transactions with any of them are returned,
+// or with all of them when matchAll is set.
+func (t *Transaction) GetAllTransactions(username string, account string, tags []string, matchAll bool) ([]Transaction, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
-	query := `select TransactionID, username, transactionamount, transactionname, transactiondescription, category from mrkrabs.Transactions where Username = $1 and accountname = $2`
 
-	rows, err := db.QueryContext(ctx, query, username, account)
+	tags, err := cleanTags(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	// the running balance is worked out before transactions are filtered
+	query := `select * from (
+		select TransactionID, username, transactionamount, transactionname, transactiondescription, category, transactiondate, clearedstatus,
+			sum(transactionamount) over (order by transactiondate, TransactionID),
+			` + transactionFlagsColumn + `,
+			` + transactionSplitsColumn + `,
+			` + transactionTagsColumn + ` as tags,
+			t.payeeid, COALESCE((select p.name from mrkrabs.Payee p where p.payeeid = t.payeeid), '')
+		from mrkrabs.Transactions t where Username = $1 and accountname = $2
+	) t
+	where cardinality($3::text[]) = 0
+		or (not $4 and t.tags && $3::text[])
+		or ($4 and t.tags @> $3::text[])
+	order by transactiondate, TransactionID`
+
+	rows, err := db.QueryContext(ctx, query, username, account, tags, matchAll)
 	if err != nil {
 		return nil, err
 	}
@@ -261,7 +419,18 @@ func (t *Transaction) GetAllTransactions(username string, account string) ([]Tra
 	var transactions []Transaction
 	for rows.Next() {
 		var trans Transaction
-		if err := rows.Scan(&trans.TransactionID, &trans.UserID, &trans.TransactionAmount, &trans.TransactionName, &trans.TransactionDescription, &trans.TransactionCategory); err != nil {
+		var flags, tags pgtype.TextArray
+		var splits []byte
+		if err := rows.Scan(&trans.TransactionID, &trans.UserID, &trans.TransactionAmount, &trans.TransactionName, &trans.TransactionDescription, &trans.TransactionCategory, &trans.TransactionDate, &trans.ClearedStatus, &trans.RunningBalance, &flags, &splits, &tags, &trans.PayeeID, &trans.Payee); err != nil {
+			return transactions, err
+		}
+		if err := flags.AssignTo(&trans.Flags); err != nil {
+			return transactions, err
+		}
+		if err := tags.AssignTo(&trans.Tags); err != nil {
+			return transactions, err
+		}
+		if err := json.Unmarshal(splits, &trans.Splits); err != nil {
 			return transactions, err
 		}
 		transactions = append(transactions, trans)
@@ -302,7 +471,7 @@ func (t *RecurringPayment) GetAllReccurringPayments() ([]RecurringPayment, error
 func (t *RecurringPayment) GetReccurringPayments(username string, account string) ([]RecurringPayment, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
-	query := `SELECT paymentid, username, paymentamount, paymentname, paymentdescription, paymentdate, paymenttype, paymentfrequency, nextpaymentdate
+	query := `SELECT paymentid, username, paymentamount, paymentname, paymentdescription, paymentdate, paymenttype, paymentfrequency, nextpaymentdate, paymentcategory
 	FROM foreman.recurring_payment WHERE username = $1 and accountname = $2`
 
 	rows, err := db.QueryContext(ctx, query, username, account)
@@ -315,7 +484,7 @@ func (t *RecurringPayment) GetReccurringPayments(username string, account string
 
 	for rows.Next() {
 		var recurring RecurringPayment
-		if err := rows.Scan(&recurring.PaymentID, &recurring.UserName, &recurring.PaymentAmount, &recurring.PaymentName, &recurring.PaymentDescription, &recurring.PaymentDate, &recurring.PaymentType, &recurring.PaymentFrequency, &recurring.NextPaymentDate); err != nil {
+		if err := rows.Scan(&recurring.PaymentID, &recurring.UserName, &recurring.PaymentAmount, &recurring.PaymentName, &recurring.PaymentDescription, &recurring.PaymentDate, &recurring.PaymentType, &recurring.PaymentFrequency, &recurring.NextPaymentDate, &recurring.PaymentCategory); err != nil {
 			return recurring_payments, err
 		}
 		recurring_payments = append(recurring_payments, recurring)
@@ -326,13 +495,9 @@ func (t *RecurringPayment) GetReccurringPayments(username string, account string
 	return recurring_payments, nil
 }
 
-func (t *RecurringPayment) AddReccurringPayment(username string, account string, paymentAmount float32, paymentName string, paymentDescription string, paymentDate string, paymentType string, paymentFrequency string) (float32, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
-	query := `INSERT INTO foreman.recurring_payment(
-		username, accountname, paymentamount, paymentname, paymentdescription, paymentdate, paymenttype, paymentfrequency, nextpaymentdate)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);`
-
+// nextPaymentDate returns the date following paymentDate for a payment
+// frequency, or "" for frequencies that are not known.
+func nextPaymentDate(paymentDate string, paymentFrequency string) string {
 	next_payment := ""
 
 	tt, err := time.Parse("2006-01-02", paymentDate)
@@ -347,8 +512,23 @@ func (t *RecurringPayment) AddReccurringPayment(username string, account string,
 	} else if paymentFrequency == "monthly" {
 		next_payment = tt.AddDate(0, 1, 0).Format("2006-01-02")
 	}
+	return next_payment
+}
+
+func (t *RecurringPayment) AddReccurringPayment(username string, account string, paymentAmount float32, paymentName string, paymentDescription string, paymentDate string, paymentType string, paymentFrequency string) (float32, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	query := `INSERT INTO foreman.recurring_payment(
+		username, accountname, paymentamount, paymentname, paymentdescription, paymentdate, paymenttype, paymentfrequency, nextpaymentdate)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);`
+
+	if err := checkWritable(ctx, db, username, account); err != nil {
+		return 0, err
+	}
+
+	next_payment := nextPaymentDate(paymentDate, paymentFrequency)
 
-	_, err = db.ExecContext(ctx, query, username, account, paymentAmount, paymentName, paymentDescription, paymentDate, paymentType, paymentFrequency, next_payment)
+	_, err := db.ExecContext(ctx, query, username, account, paymentAmount, paymentName, paymentDescription, paymentDate, paymentType, paymentFrequency, next_payment)
 
 	if err != nil {
 		return 0, err
@@ -357,13 +537,15 @@ func (t *RecurringPayment) AddReccurringPayment(username string, account string,
 	return 1, err
 }
 
-func (t *PaymentHistory) GetPaymentHistory(paymentID int) ([]PaymentHistory, error) {
+func (t *PaymentHistory) GetPaymentHistory(username string, paymentID int) ([]PaymentHistory, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
-	query := `SELECT paymenthistoryid, paymentid, paymenthistorydate, paymenthistorystatus
-				FROM foreman.payment_history WHERE paymentid = $1`
+	query := `SELECT h.paymenthistoryid, h.paymentid, h.paymenthistorydate, h.paymenthistorystatus
+				FROM foreman.payment_history h
+				JOIN foreman.recurring_payment p ON p.paymentid = h.paymentid
+				WHERE h.paymentid = $1 AND p.username = $2`
 
-	rows, err := db.QueryContext(ctx, query, paymentID)
+	rows, err := db.QueryContext(ctx, query, paymentID, username)
 	if err != nil {
 		return nil, err
 	}
@@ -436,6 +618,10 @@ func (d *Debt) CreateDebt(userID string, account string, totalOwing float32, nam
 	RETURNING DebtID;
 	`
 
+	if err := checkWritable(ctx, db, userID, account); err != nil {
+		return -1, err
+	}
+
 	var debtID int
 
 	row := db.QueryRowContext(ctx, query, userID, account, totalOwing, name)
@@ -487,10 +673,7 @@ func (d *Debt) MakeDebtPayment(userID string, account string, debtID int, amount
 	if amount > 0 {
 		amount = amount * -1
 	}
-	fmt.Sprintf("amt:", amount)
-	var t *Transaction
 	var debt Debt
-	var transactionID int
 
 	// check if debt exists
 	debt, err := d.GetDebtByID(debtID, userID, account)
@@ -498,32 +681,36 @@ func (d *Debt) MakeDebtPayment(userID string, account string, debtID int, amount
 		return debt, err
 	}
 
-	// create transaction
-	query := `insert into mrkrabs.Transactions (Username, AccountName, TransactionAmount, TransactionName, TransactionDescription, Category) values
-	($1,$2,$3,$4,$5,$6)
-	RETURNING TransactionID`
-	balance, err := t.GetUserBalance(userID, account)
-	if err != nil && err.Error() != "sql: no rows in result set" {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
 		return debt, err
 	}
-	log.Println(balance)
-	if (balance + amount) < 0 {
-		return debt, errors.New("error. can not decrement balance below zero")
-	}
-	row := db.QueryRowContext(ctx, query, userID, account, amount, fmt.Sprintf("balance payment for debt %d", debtID), "", "Debt")
-	err = row.Scan(&transactionID)
+	defer tx.Rollback()
+
+	// create transaction
+	transactionID, _, err := postTransaction(ctx, tx, pendingTransaction{
+		Username: userID,
+		Account:  account,
+		Amount:   amount,
+		Name:     fmt.Sprintf("balance payment for debt %d", debtID),
+		Category: CategoryDebt,
+	})
 	if err != nil {
 		return debt, err
 	}
 
 	// insert transaction with debt
-	query = `insert into mrkrabs.DebtPayment (TransactionID, DebtID)
+	query := `insert into mrkrabs.DebtPayment (TransactionID, DebtID)
 	values ($1,$2)`
-	_, err = db.QueryContext(ctx, query, transactionID, debtID)
+	_, err = tx.ExecContext(ctx, query, transactionID, debtID)
 	if err != nil {
 		return debt, err
 	}
 
+	if err = tx.Commit(); err != nil {
+		return debt, err
+	}
+
 	debt, err = d.GetDebtByID(debtID, userID, account)
 	if err != nil {
 		return debt, err
@@ -0,0 +1,322 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// maxNetWorthMonths bounds the length of the net worth history.
+const maxNetWorthMonths = 120
+
+// Asset is something of value a user tracks by hand, such as a house or a
+// car. Every change of its value is kept so the net worth history can use the
+// value an asset had at the time.
+type Asset struct {
+	AssetID  int       `json:"assetID"`
+	Username string    `json:"username"`
+	Name     string    `json:"name"`
+	Value    float32   `json:"value"`
+	ValuedAt time.Time `json:"valuedAt"`
+}
+
+// NetWorth is the overall position of a user: the balances of their accounts
+// and their assets, less what is still owed on their debts.
+type NetWorth struct {
+	Accounts     []NetWorthAccount `json:"accounts"`
+	Assets       []Asset           `json:"assets"`
+	Debts        []NetWorthDebt    `json:"debts"`
+	AccountTotal float32           `json:"accountTotal"`
+	AssetTotal   float32           `json:"assetTotal"`
+	DebtTotal    float32           `json:"debtTotal"`
+	NetWorth     float32           `json:"netWorth"`
+	History      []NetWorthPoint   `json:"history"`
+}
+
+type NetWorthAccount struct {
+	AccountName string  `json:"accountname"`
+	AccountType string  `json:"accountType"`
+	Status      string  `json:"status"`
+	Balance     float32 `json:"balance"`
+}
+
+type NetWorthDebt struct {
+	DebtID      int     `json:"debtID"`
+	AccountName string  `json:"accountname"`
+	Name        string  `json:"name"`
+	Outstanding float32 `json:"outstanding"`
+}
+
+// NetWorthPoint is the net worth of a user at the end of a month.
+type NetWorthPoint struct {
+	Month    string  `json:"month"`
+	Accounts float32 `json:"accounts"`
+	Assets   float32 `json:"assets"`
+	Debts    float32 `json:"debts"`
+	NetWorth float32 `json:"netWorth"`
+}
+
+// assetColumns selects an asset with its latest value.
+const assetColumns = `s.assetid, s.username, s.name, v.value, v.valuedat
+	FROM mrkrabs.Asset s
+	CROSS JOIN LATERAL (SELECT value, valuedat FROM mrkrabs.AssetValue
+		WHERE assetid = s.assetid ORDER BY valuedat DESC LIMIT 1) v`
+
+func scanAsset(row interface{ Scan(...any) error }) (Asset, error) {
+	var a Asset
+	err := row.Scan(&a.AssetID, &a.Username, &a.Name, &a.Value, &a.ValuedAt)
+	return a, err
+}
+
+func validateAsset(name string, value float32) error {
+	if name == "" {
+		return errors.New("an asset needs a name")
+	}
+	if value < 0 {
+		return errors.New("asset value can not be negative, track what is owed as a debt")
+	}
+	return nil
+}
+
+func getAssets(ctx context.Context, q querier, email string) ([]Asset, error) {
+	query := `SELECT ` + assetColumns + ` WHERE s.username = $1 ORDER BY s.name, s.assetid`
+
+	rows, err := q.QueryContext(ctx, query, email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assets := []Asset{}
+	for rows.Next() {
+		asset, err := scanAsset(rows)
+		if err != nil {
+			return assets, err
+		}
+		assets = append(assets, asset)
+	}
+	if err = rows.Err(); err != nil {
+		return assets, err
+	}
+	return assets, nil
+}
+
+func (a *Asset) GetAssets(email string) ([]Asset, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return getAssets(ctx, db, email)
+}
+
+func (a *Asset) CreateAsset(email string, name string, value float32) (Asset, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if err := validateAsset(name, value); err != nil {
+		return Asset{}, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Asset{}, err
+	}
+	defer tx.Rollback()
+
+	asset := Asset{Username: email, Name: name, Value: value}
+	query := `INSERT INTO mrkrabs.Asset (username, name) VALUES ($1, $2) RETURNING assetid`
+	if err := tx.QueryRowContext(ctx, query, email, name).Scan(&asset.AssetID); err != nil {
+		return Asset{}, err
+	}
+	query = `INSERT INTO mrkrabs.AssetValue (assetid, value) VALUES ($1, $2) RETURNING valuedat`
+	if err := tx.QueryRowContext(ctx, query, asset.AssetID, value).Scan(&asset.ValuedAt); err != nil {
+		return Asset{}, err
+	}
+	return asset, tx.Commit()
+}
+
+// UpdateAsset renames an asset and records its value as of now when the value
+// changed.
+func (a *Asset) UpdateAsset(email string, assetID int, name string, value float32) (Asset, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if err := validateAsset(name, value); err != nil {
+		return Asset{}, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Asset{}, err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE mrkrabs.Asset SET name = $1 WHERE assetid = $2 AND username = $3`
+	res, err := tx.ExecContext(ctx, query, name, assetID, email)
+	if err != nil {
+		return Asset{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return Asset{}, err
+	}
+	if n == 0 {
+		return Asset{}, fmt.Errorf("asset %d not found", assetID)
+	}
+
+	query = `INSERT INTO mrkrabs.AssetValue (assetid, value)
+	SELECT $1::int, $2::real
+	WHERE $2::real IS DISTINCT FROM (SELECT value FROM mrkrabs.AssetValue WHERE assetid = $1 ORDER BY valuedat DESC LIMIT 1)`
+	if _, err := tx.ExecContext(ctx, query, assetID, value); err != nil {
+		return Asset{}, err
+	}
+
+	asset, err := scanAsset(tx.QueryRowContext(ctx, `SELECT `+assetColumns+` WHERE s.assetid = $1`, assetID))
+	if err != nil {
+		return asset, err
+	}
+	return asset, tx.Commit()
+}
+
+func (a *Asset) DeleteAsset(email string, assetID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int
+	query := `DELETE FROM mrkrabs.Asset WHERE assetid = $1 AND username = $2 RETURNING assetid`
+	err = tx.QueryRowContext(ctx, query, assetID, email).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("asset %d not found", assetID)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mrkrabs.AssetValue WHERE assetid = $1`, assetID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetNetWorth returns the current net worth of a user across all of their
+// accounts, including archived and closed ones, with a history of the last
+// months. Debts count in full until payments are made against them.
+func (t *Account) GetNetWorth(email string, months int) (NetWorth, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if months < 1 || months > maxNetWorthMonths {
+		return NetWorth{}, fmt.Errorf("net worth history must cover 1 to %d months", maxNetWorthMonths)
+	}
+
+	nw := NetWorth{Accounts: []NetWorthAccount{}, Debts: []NetWorthDebt{}, History: []NetWorthPoint{}}
+
+	query := `SELECT a.accountname, a.accounttype, a.status,
+		COALESCE((SELECT SUM(t.transactionamount) FROM mrkrabs.Transactions t
+			WHERE t.username = a.username AND t.accountname = a.accountname), 0)
+	FROM mrkrabs.Account a
+	WHERE a.username = $1
+	ORDER BY a.accountname`
+
+	rows, err := db.QueryContext(ctx, query, email)
+	if err != nil {
+		return nw, err
+	}
+	for rows.Next() {
+		var a NetWorthAccount
+		if err := rows.Scan(&a.AccountName, &a.AccountType, &a.Status, &a.Balance); err != nil {
+			rows.Close()
+			return nw, err
+		}
+		nw.Accounts = append(nw.Accounts, a)
+		nw.AccountTotal += a.Balance
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nw, err
+	}
+
+	query = `SELECT d.debtid, d.accountname, d.name,
+		GREATEST(d.totalowing - COALESCE((SELECT -SUM(t.transactionamount) FROM mrkrabs.DebtPayment p
+			JOIN mrkrabs.Transactions t ON t.transactionid = p.transactionid
+			WHERE p.debtid = d.debtid), 0), 0)
+	FROM mrkrabs.Debt d
+	WHERE d.userid = $1
+	ORDER BY d.accountname, d.debtid`
+
+	rows, err = db.QueryContext(ctx, query, email)
+	if err != nil {
+		return nw, err
+	}
+	for rows.Next() {
+		var d NetWorthDebt
+		if err := rows.Scan(&d.DebtID, &d.AccountName, &d.Name, &d.Outstanding); err != nil {
+			rows.Close()
+			return nw, err
+		}
+		nw.Debts = append(nw.Debts, d)
+		nw.DebtTotal += d.Outstanding
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nw, err
+	}
+
+	nw.Assets, err = getAssets(ctx, db, email)
+	if err != nil {
+		return nw, err
+	}
+	for _, a := range nw.Assets {
+		nw.AssetTotal += a.Value
+	}
+	nw.NetWorth = nw.AccountTotal + nw.AssetTotal - nw.DebtTotal
+
+	// each month is valued at its end: account balances from the transactions
+	// up to then, the latest value of each asset and what was still owed
+	query = `WITH months AS (
+		SELECT m AS month FROM generate_series(
+			date_trunc('month', now()) - ($2::int - 1) * interval '1 month',
+			date_trunc('month', now()),
+			interval '1 month') m
+	)
+	SELECT to_char(m.month, 'YYYY-MM'),
+		COALESCE((SELECT SUM(t.transactionamount) FROM mrkrabs.Transactions t
+			JOIN mrkrabs.Account a ON a.username = t.username AND a.accountname = t.accountname
+			WHERE t.username = $1 AND t.transactiondate < m.month + interval '1 month'), 0),
+		COALESCE((SELECT SUM(v.value) FROM mrkrabs.Asset s
+			CROSS JOIN LATERAL (SELECT value FROM mrkrabs.AssetValue
+				WHERE assetid = s.assetid AND valuedat < m.month + interval '1 month'
+				ORDER BY valuedat DESC LIMIT 1) v
+			WHERE s.username = $1), 0),
+		COALESCE((SELECT SUM(GREATEST(d.totalowing - COALESCE((SELECT -SUM(t.transactionamount) FROM mrkrabs.DebtPayment p
+				JOIN mrkrabs.Transactions t ON t.transactionid = p.transactionid
+				WHERE p.debtid = d.debtid AND t.transactiondate < m.month + interval '1 month'), 0), 0))
+			FROM mrkrabs.Debt d WHERE d.userid = $1), 0)
+	FROM months m
+	ORDER BY m.month`
+
+	rows, err = db.QueryContext(ctx, query, email, months)
+	if err != nil {
+		return nw, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p NetWorthPoint
+		if err := rows.Scan(&p.Month, &p.Accounts, &p.Assets, &p.Debts); err != nil {
+			return nw, err
+		}
+		p.NetWorth = p.Accounts + p.Assets - p.Debts
+		nw.History = append(nw.History, p)
+	}
+	if err = rows.Err(); err != nil {
+		return nw, err
+	}
+	return nw, nil
+}
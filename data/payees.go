@@ -0,0 +1,693 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgtype"
+)
+
+const PayeeMatchExact = "exact"
+
+// maxPayeeNameLength bounds the length of a payee name.
+const maxPayeeNameLength = 100
+
+// PayeeRule maps raw transaction names to a payee. Exact and contains rules
+// compare normalized names, regex rules the name as it was posted.
+type PayeeRule struct {
+	RuleID    int    `json:"ruleID"`
+	MatchType string `json:"matchType"`
+	Pattern   string `json:"pattern"`
+
+	re *regexp.Regexp
+}
+
+// Payee is the merchant or person behind transactions, whatever name each
+// of them was posted with. Transactions without a category get the default
+// category of their payee.
+type Payee struct {
+	PayeeID         int         `json:"payeeID"`
+	AccountName     string      `json:"accountname"`
+	Name            string      `json:"name"`
+	DefaultCategory string      `json:"defaultCategory"`
+	Rules           []PayeeRule `json:"rules"`
+	CreatedAt       time.Time   `json:"createdAt"`
+}
+
+// PayeeTotal is the money that went through the transactions of one payee.
+// Expenses are given as a positive amount.
+type PayeeTotal struct {
+	PayeeID   int        `json:"payeeID"`
+	Payee     string     `json:"payee"`
+	Income    float32    `json:"income"`
+	Expenses  float32    `json:"expenses"`
+	Net       float32    `json:"net"`
+	Count     int        `json:"count"`
+	FirstDate *time.Time `json:"firstDate"`
+	LastDate  *time.Time `json:"lastDate"`
+}
+
+// PayeeMonth is the money that went through the transactions of a payee in
+// one month, given as 2006-01.
+type PayeeMonth struct {
+	Month    string  `json:"month"`
+	Income   float32 `json:"income"`
+	Expenses float32 `json:"expenses"`
+	Net      float32 `json:"net"`
+	Count    int     `json:"count"`
+}
+
+// PayeeHistory is the spending with one payee: its totals, month by month
+// and transaction by transaction.
+type PayeeHistory struct {
+	Payee        Payee         `json:"payee"`
+	Totals       PayeeTotal    `json:"totals"`
+	Months       []PayeeMonth  `json:"months"`
+	Transactions []Transaction `json:"transactions"`
+}
+
+// PayeeChange is what assigning payees did to one transaction.
+type PayeeChange struct {
+	TransactionID   int    `json:"transaction_id"`
+	TransactionName string `json:"transactionName"`
+	OldPayeeID      *int   `json:"oldPayeeID"`
+	NewPayeeID      *int   `json:"newPayeeID"`
+	NewPayee        string `json:"newPayee"`
+	OldCategory     string `json:"oldCategory"`
+	NewCategory     string `json:"newCategory"`
+}
+
+// payeeReference matches the store numbers and payment references banks add
+// to names, after a * or a #.
+var payeeReference = regexp.MustCompile(`[*#].*$`)
+
+// normalizePayeeName reduces a raw transaction name to what identifies its
+// payee: lower case, without references, punctuation and numbers, so that
+// "AMZN Mktp CA*1234" becomes "amzn mktp ca". Hyphens and apostrophes join
+// words, so "7-Eleven" stays "7eleven".
+func normalizePayeeName(name string) string {
+	name = payeeReference.ReplaceAllString(strings.ToLower(name), "")
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '&':
+			return r
+		case r == '-' || r == '\'' || r == '’':
+			return -1
+		}
+		return ' '
+	}, name)
+
+	var words []string
+	for _, w := range strings.Fields(name) {
+		if strings.IndexFunc(w, func(r rune) bool { return !unicode.IsDigit(r) }) >= 0 {
+			words = append(words, w)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+func (r *PayeeRule) compile() error {
+	switch r.MatchType {
+	case PayeeMatchExact, RuleMatchContains:
+		r.re = nil
+		if normalizePayeeName(r.Pattern) == "" {
+			return fmt.Errorf("pattern %q has nothing left to match once normalized", r.Pattern)
+		}
+	case RuleMatchRegex:
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %w", err)
+		}
+		r.re = re
+	default:
+		return fmt.Errorf("unknown match type %q", r.MatchType)
+	}
+	return nil
+}
+
+func (p *Payee) compile() error {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		return errors.New("payee name is required")
+	}
+	if len(p.Name) > maxPayeeNameLength {
+		return fmt.Errorf("payee names can be at most %d characters", maxPayeeNameLength)
+	}
+	if p.Rules == nil {
+		p.Rules = []PayeeRule{}
+	}
+	for i := range p.Rules {
+		if err := p.Rules[i].compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// payeeRulesColumn selects the rules of the payee p as a JSON array.
+const payeeRulesColumn = `COALESCE((SELECT json_agg(json_build_object('ruleID', r.ruleid, 'matchType', r.matchtype, 'pattern', r.pattern)
+		ORDER BY r.ruleid) FROM mrkrabs.PayeeRule r WHERE r.payeeid = p.payeeid), '[]')`
+
+const payeeColumns = `p.payeeid, p.accountname, p.name, p.category, p.createdat, ` + payeeRulesColumn
+
+func scanPayee(row interface{ Scan(...any) error }) (Payee, error) {
+	var p Payee
+	var rules []byte
+
+	err := row.Scan(&p.PayeeID, &p.AccountName, &p.Name, &p.DefaultCategory, &p.CreatedAt, &rules)
+	if err != nil {
+		return p, err
+	}
+	if err := json.Unmarshal(rules, &p.Rules); err != nil {
+		return p, err
+	}
+	return p, p.compile()
+}
+
+func getPayees(ctx context.Context, q querier, username string, account string) ([]Payee, error) {
+	query := `SELECT ` + payeeColumns + ` FROM mrkrabs.Payee p
+	WHERE p.username = $1 AND p.accountname = $2 ORDER BY p.name, p.payeeid`
+
+	rows, err := q.QueryContext(ctx, query, username, account)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payees := []Payee{}
+	for rows.Next() {
+		payee, err := scanPayee(rows)
+		if err != nil {
+			return payees, err
+		}
+		payees = append(payees, payee)
+	}
+	if err = rows.Err(); err != nil {
+		return payees, err
+	}
+	return payees, nil
+}
+
+func getPayee(ctx context.Context, q querier, username string, account string, payeeID int) (Payee, error) {
+	query := `SELECT ` + payeeColumns + ` FROM mrkrabs.Payee p
+	WHERE p.payeeid = $1 AND p.username = $2 AND p.accountname = $3`
+
+	payee, err := scanPayee(q.QueryRowContext(ctx, query, payeeID, username, account))
+	if errors.Is(err, sql.ErrNoRows) {
+		return payee, fmt.Errorf("payee %d not found", payeeID)
+	}
+	return payee, err
+}
+
+// matchPayee returns the payee of a raw transaction name, or nil. A payee
+// whose own name or exact rule matches wins, then the longest contains rule,
+// then the first regex rule.
+func matchPayee(payees []Payee, name string) *Payee {
+	normalized := normalizePayeeName(name)
+	if normalized == "" {
+		return nil
+	}
+
+	var contains *Payee
+	var longest int
+	var regex *Payee
+	for i := range payees {
+		p := &payees[i]
+		if normalizePayeeName(p.Name) == normalized {
+			return p
+		}
+		for _, r := range p.Rules {
+			switch r.MatchType {
+			case PayeeMatchExact:
+				if normalizePayeeName(r.Pattern) == normalized {
+					return p
+				}
+			case RuleMatchContains:
+				pattern := normalizePayeeName(r.Pattern)
+				if len(pattern) > longest && strings.Contains(normalized, pattern) {
+					contains, longest = p, len(pattern)
+				}
+			case RuleMatchRegex:
+				if regex == nil && r.re.MatchString(name) {
+					regex = p
+				}
+			}
+		}
+	}
+	if contains != nil {
+		return contains
+	}
+	return regex
+}
+
+// applyPayees finds the payee of a transaction and, when the transaction has
+// no category yet, gives it the default category of the payee.
+func applyPayees(payees []Payee, name string, category string) (*int, string) {
+	payee := matchPayee(payees, name)
+	if payee == nil {
+		return nil, category
+	}
+	if category == "" {
+		category = payee.DefaultCategory
+	}
+	id := payee.PayeeID
+	return &id, category
+}
+
+func (p *Payee) GetPayees(username string, account string) ([]Payee, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return getPayees(ctx, db, username, account)
+}
+
+func (p *Payee) GetPayee(username string, account string, payeeID int) (Payee, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return getPayee(ctx, db, username, account, payeeID)
+}
+
+// savePayee checks a payee before it is saved. Names are unique within an
+// account once normalized.
+func savePayee(ctx context.Context, tx *sql.Tx, username string, account string, payeeID int, payee *Payee) error {
+	if err := payee.compile(); err != nil {
+		return err
+	}
+	if err := checkWritable(ctx, tx, username, account); err != nil {
+		return err
+	}
+	if err := validateCategory(ctx, tx, username, account, payee.DefaultCategory); err != nil {
+		return err
+	}
+
+	payees, err := getPayees(ctx, tx, username, account)
+	if err != nil {
+		return err
+	}
+	for _, other := range payees {
+		if other.PayeeID != payeeID && normalizePayeeName(other.Name) == normalizePayeeName(payee.Name) {
+			return fmt.Errorf("payee %s already exists", other.Name)
+		}
+	}
+	return nil
+}
+
+func insertPayeeRules(ctx context.Context, tx *sql.Tx, payeeID int, rules []PayeeRule) error {
+	query := `INSERT INTO mrkrabs.PayeeRule (payeeid, matchtype, pattern) VALUES ($1, $2, $3)`
+
+	for _, r := range rules {
+		if _, err := tx.ExecContext(ctx, query, payeeID, r.MatchType, r.Pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Payee) CreatePayee(username string, account string, payee Payee) (Payee, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Payee{}, err
+	}
+	defer tx.Rollback()
+
+	if err := savePayee(ctx, tx, username, account, 0, &payee); err != nil {
+		return Payee{}, err
+	}
+
+	var payeeID int
+	query := `INSERT INTO mrkrabs.Payee (username, accountname, name, category, createdat)
+	VALUES ($1, $2, $3, $4, now())
+	RETURNING payeeid`
+	if err := tx.QueryRowContext(ctx, query, username, account, payee.Name, payee.DefaultCategory).Scan(&payeeID); err != nil {
+		return Payee{}, err
+	}
+	if err := insertPayeeRules(ctx, tx, payeeID, payee.Rules); err != nil {
+		return Payee{}, err
+	}
+
+	created, err := getPayee(ctx, tx, username, account, payeeID)
+	if err != nil {
+		return Payee{}, err
+	}
+	return created, tx.Commit()
+}
+
+// UpdatePayee renames a payee, changes its default category and replaces its
+// rules. Transactions already assigned to it keep their category.
+func (p *Payee) UpdatePayee(username string, account string, payeeID int, payee Payee) (Payee, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Payee{}, err
+	}
+	defer tx.Rollback()
+
+	if err := savePayee(ctx, tx, username, account, payeeID, &payee); err != nil {
+		return Payee{}, err
+	}
+
+	query := `UPDATE mrkrabs.Payee SET name = $1, category = $2
+	WHERE payeeid = $3 AND username = $4 AND accountname = $5`
+	res, err := tx.ExecContext(ctx, query, payee.Name, payee.DefaultCategory, payeeID, username, account)
+	if err != nil {
+		return Payee{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return Payee{}, err
+	}
+	if n == 0 {
+		return Payee{}, fmt.Errorf("payee %d not found", payeeID)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mrkrabs.PayeeRule WHERE payeeid = $1`, payeeID); err != nil {
+		return Payee{}, err
+	}
+	if err := insertPayeeRules(ctx, tx, payeeID, payee.Rules); err != nil {
+		return Payee{}, err
+	}
+
+	updated, err := getPayee(ctx, tx, username, account, payeeID)
+	if err != nil {
+		return Payee{}, err
+	}
+	return updated, tx.Commit()
+}
+
+// DeletePayee removes a payee and its rules. Its transactions are kept
+// without a payee.
+func (p *Payee) DeletePayee(username string, account string, payeeID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkWritable(ctx, tx, username, account); err != nil {
+		return err
+	}
+	if _, err := getPayee(ctx, tx, username, account, payeeID); err != nil {
+		return err
+	}
+
+	query := `UPDATE mrkrabs.Transactions SET payeeid = NULL
+	WHERE payeeid = $1 AND username = $2 AND accountname = $3`
+	if _, err := tx.ExecContext(ctx, query, payeeID, username, account); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mrkrabs.PayeeRule WHERE payeeid = $1`, payeeID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mrkrabs.Payee WHERE payeeid = $1`, payeeID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AssignPayees runs the payees of an account over all of its existing
+// transactions and returns what changed. Transactions that already have a
+// payee keep it unless overwrite is set; ones without a category get the
+// default category of their payee. Nothing is saved when dryRun is set.
+// Reconciled transactions are left alone.
+func (p *Payee) AssignPayees(username string, account string, overwrite bool, dryRun bool) ([]PayeeChange, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout*10)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := checkWritable(ctx, tx, username, account); err != nil {
+		return nil, err
+	}
+	payees, err := getPayees(ctx, tx, username, account)
+	if err != nil {
+		return nil, err
+	}
+	names := map[int]string{}
+	for _, payee := range payees {
+		names[payee.PayeeID] = payee.Name
+	}
+
+	query := `SELECT transactionid, transactionname, category, payeeid
+	FROM mrkrabs.Transactions
+	WHERE username = $1 AND accountname = $2 AND clearedstatus <> $3
+	ORDER BY transactionid`
+
+	rows, err := tx.QueryContext(ctx, query, username, account, ClearedStatusReconciled)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []PayeeChange{}
+	for rows.Next() {
+		var id int
+		var name, category string
+		var payeeID *int
+		if err := rows.Scan(&id, &name, &category, &payeeID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		newPayeeID, newCategory := payeeID, category
+		if payeeID == nil || overwrite {
+			newPayeeID, newCategory = applyPayees(payees, name, category)
+		} else if category == "" {
+			for _, payee := range payees {
+				if payee.PayeeID == *payeeID {
+					newCategory = payee.DefaultCategory
+				}
+			}
+		}
+		if newPayeeID == nil && payeeID != nil {
+			// a payee set by hand is kept when no rule matches
+			newPayeeID = payeeID
+		}
+
+		samePayee := (newPayeeID == nil && payeeID == nil) || (newPayeeID != nil && payeeID != nil && *newPayeeID == *payeeID)
+		if samePayee && newCategory == category {
+			continue
+		}
+		change := PayeeChange{
+			TransactionID:   id,
+			TransactionName: name,
+			OldPayeeID:      payeeID,
+			NewPayeeID:      newPayeeID,
+			OldCategory:     category,
+			NewCategory:     newCategory,
+		}
+		if newPayeeID != nil {
+			change.NewPayee = names[*newPayeeID]
+		}
+		changes = append(changes, change)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if dryRun {
+		return changes, nil
+	}
+
+	update := `UPDATE mrkrabs.Transactions SET payeeid = $1, category = $2 WHERE transactionid = $3`
+	for _, c := range changes {
+		if _, err := tx.ExecContext(ctx, update, c.NewPayeeID, c.NewCategory, c.TransactionID); err != nil {
+			return nil, err
+		}
+	}
+	return changes, tx.Commit()
+}
+
+// SetTransactionPayee assigns a transaction to a payee by hand, or takes it
+// off its payee when payeeID is nil. Reconciled transactions can not be
+// changed.
+func (p *Payee) SetTransactionPayee(username string, account string, transactionID int, payeeID *int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkWritable(ctx, tx, username, account); err != nil {
+		return err
+	}
+	if err := checkNotReconciled(ctx, tx, username, account, transactionID); err != nil {
+		return err
+	}
+	if payeeID != nil {
+		if _, err := getPayee(ctx, tx, username, account, *payeeID); err != nil {
+			return err
+		}
+	}
+
+	query := `UPDATE mrkrabs.Transactions SET payeeid = $1
+	WHERE transactionid = $2 AND username = $3 AND accountname = $4`
+	res, err := tx.ExecContext(ctx, query, payeeID, transactionID, username, account)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("transaction %d not found", transactionID)
+	}
+	return tx.Commit()
+}
+
+// payeeTotalsQuery sums the transactions of each payee of an account between
+// $3 and $4, either of which may be null. $5 picks a single payee.
+const payeeTotalsQuery = `SELECT p.payeeid, p.name,
+		COALESCE(SUM(t.transactionamount) FILTER (WHERE t.transactionamount > 0), 0),
+		COALESCE(-SUM(t.transactionamount) FILTER (WHERE t.transactionamount < 0), 0),
+		COALESCE(SUM(t.transactionamount), 0),
+		COUNT(t.transactionid),
+		MIN(t.transactiondate),
+		MAX(t.transactiondate)
+	FROM mrkrabs.Payee p
+	LEFT JOIN mrkrabs.Transactions t ON t.payeeid = p.payeeid
+		AND ($3::timestamptz IS NULL OR t.transactiondate >= $3) AND ($4::timestamptz IS NULL OR t.transactiondate < $4)
+	WHERE p.username = $1 AND p.accountname = $2 AND ($5::int IS NULL OR p.payeeid = $5)
+	GROUP BY p.payeeid, p.name`
+
+func scanPayeeTotal(row interface{ Scan(...any) error }) (PayeeTotal, error) {
+	var total PayeeTotal
+	err := row.Scan(&total.PayeeID, &total.Payee, &total.Income, &total.Expenses, &total.Net, &total.Count, &total.FirstDate, &total.LastDate)
+	return total, err
+}
+
+// GetPayeeTotals returns every payee of an account with the totals of its
+// transactions between from and to, biggest spending first. Either bound may
+// be nil.
+func (p *Payee) GetPayeeTotals(username string, account string, from *time.Time, to *time.Time) ([]PayeeTotal, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, payeeTotalsQuery, username, account, from, to, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := []PayeeTotal{}
+	for rows.Next() {
+		total, err := scanPayeeTotal(rows)
+		if err != nil {
+			return totals, err
+		}
+		totals = append(totals, total)
+	}
+	if err = rows.Err(); err != nil {
+		return totals, err
+	}
+	sort.SliceStable(totals, func(i, j int) bool {
+		if totals[i].Expenses != totals[j].Expenses {
+			return totals[i].Expenses > totals[j].Expenses
+		}
+		return totals[i].Payee < totals[j].Payee
+	})
+	return totals, nil
+}
+
+// GetPayeeHistory returns the spending with one payee between from and to,
+// with its totals, a line per month and its transactions. Either bound may be
+// nil.
+func (p *Payee) GetPayeeHistory(username string, account string, payeeID int, from *time.Time, to *time.Time) (PayeeHistory, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var history PayeeHistory
+	payee, err := getPayee(ctx, db, username, account, payeeID)
+	if err != nil {
+		return history, err
+	}
+	history.Payee = payee
+
+	history.Totals, err = scanPayeeTotal(db.QueryRowContext(ctx, payeeTotalsQuery, username, account, from, to, payeeID))
+	if err != nil {
+		return history, err
+	}
+
+	query := `SELECT to_char(date_trunc('month', transactiondate), 'YYYY-MM'),
+		COALESCE(SUM(transactionamount) FILTER (WHERE transactionamount > 0), 0),
+		COALESCE(-SUM(transactionamount) FILTER (WHERE transactionamount < 0), 0),
+		SUM(transactionamount),
+		COUNT(*)
+	FROM mrkrabs.Transactions
+	WHERE username = $1 AND accountname = $2 AND payeeid = $3
+		AND ($4::timestamptz IS NULL OR transactiondate >= $4) AND ($5::timestamptz IS NULL OR transactiondate < $5)
+	GROUP BY 1
+	ORDER BY 1`
+
+	rows, err := db.QueryContext(ctx, query, username, account, payeeID, from, to)
+	if err != nil {
+		return history, err
+	}
+	history.Months = []PayeeMonth{}
+	for rows.Next() {
+		var m PayeeMonth
+		if err := rows.Scan(&m.Month, &m.Income, &m.Expenses, &m.Net, &m.Count); err != nil {
+			rows.Close()
+			return history, err
+		}
+		history.Months = append(history.Months, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return history, err
+	}
+
+	query = `SELECT t.transactionid, t.username, t.transactionamount, t.transactionname, t.transactiondescription, t.category, t.transactiondate, t.clearedstatus,
+		` + transactionTagsColumn + `
+	FROM mrkrabs.Transactions t
+	WHERE t.username = $1 AND t.accountname = $2 AND t.payeeid = $3
+		AND ($4::timestamptz IS NULL OR t.transactiondate >= $4) AND ($5::timestamptz IS NULL OR t.transactiondate < $5)
+	ORDER BY t.transactiondate, t.transactionid`
+
+	rows, err = db.QueryContext(ctx, query, username, account, payeeID, from, to)
+	if err != nil {
+		return history, err
+	}
+	defer rows.Close()
+
+	history.Transactions = []Transaction{}
+	for rows.Next() {
+		trans := Transaction{PayeeID: &payee.PayeeID, Payee: payee.Name}
+		var tags pgtype.TextArray
+		if err := rows.Scan(&trans.TransactionID, &trans.UserID, &trans.TransactionAmount, &trans.TransactionName, &trans.TransactionDescription, &trans.TransactionCategory, &trans.TransactionDate, &trans.ClearedStatus, &tags); err != nil {
+			return history, err
+		}
+		if err := tags.AssignTo(&trans.Tags); err != nil {
+			return history, err
+		}
+		history.Transactions = append(history.Transactions, trans)
+	}
+	if err = rows.Err(); err != nil {
+		return history, err
+	}
+	return history, nil
+}
@@ -0,0 +1,201 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// BalancePolicy limits how far an account's balance may fall.
+//
+// MinimumBalance replaces the floor of the account type when it is set.
+// OverdraftLimit is extra room below that floor; every transaction that
+// leaves the balance in the overdraft is followed by an OverdraftFee
+// transaction when a fee is set, and the fee has to fit within the limit too.
+// Recurring payments only get the overdraft room when RecurringMayOverdraw is
+// set.
+type BalancePolicy struct {
+	MinimumBalance       *float32 `json:"minimumBalance"`
+	OverdraftLimit       float32  `json:"overdraftLimit"`
+	OverdraftFee         float32  `json:"overdraftFee"`
+	RecurringMayOverdraw bool     `json:"recurringMayOverdraw"`
+}
+
+// BalanceError is returned when a transaction is refused by the type or
+// balance policy of an account.
+type BalanceError struct {
+	Account string  `json:"account"`
+	Balance float32 `json:"balance"`
+	Amount  float32 `json:"amount"`
+	Reason  string  `json:"reason"`
+}
+
+func (e *BalanceError) Error() string {
+	return fmt.Sprintf("error. %s", e.Reason)
+}
+
+// minimum returns the lowest balance the account may reach without going
+// into overdraft, and false when there is no floor at all.
+func (t *Account) minimum() (float32, bool) {
+	if t.Policy.MinimumBalance != nil {
+		return *t.Policy.MinimumBalance, true
+	}
+	return t.typeMinimum()
+}
+
+// checkTransaction is the single place where the balance rules of an account
+// are enforced. It returns the overdraft fee to charge after the transaction,
+// if any.
+func (t *Account) checkTransaction(ctx context.Context, tx *sql.Tx, balance float32, amount float32, recurring bool) (float32, error) {
+	if amount >= 0 {
+		return 0, nil
+	}
+
+	refuse := func(reason string) error {
+		return &BalanceError{Account: t.AccountName, Balance: balance, Amount: amount, Reason: reason}
+	}
+
+	if t.AccountType == AccountTypeSavings && t.MonthlyWithdrawalLimit > 0 {
+		n, err := t.withdrawalsThisMonth(ctx, tx)
+		if err != nil {
+			return 0, err
+		}
+		if n >= t.MonthlyWithdrawalLimit {
+			return 0, refuse(fmt.Sprintf("savings account allows %d withdrawals per month", t.MonthlyWithdrawalLimit))
+		}
+	}
+
+	min, ok := t.minimum()
+	if !ok || balance+amount >= min {
+		return 0, nil
+	}
+
+	overdraft := t.Policy.OverdraftLimit
+	if recurring && !t.Policy.RecurringMayOverdraw {
+		overdraft = 0
+	}
+	// the fee is charged from the same overdraft room
+	fee := t.Policy.OverdraftFee
+	if balance+amount-fee < min-overdraft {
+		switch {
+		case overdraft > 0:
+			return 0, refuse("transaction would exceed the overdraft limit")
+		case t.IsCredit() && t.Policy.MinimumBalance == nil:
+			return 0, refuse("transaction would exceed the credit limit")
+		case min == 0:
+			return 0, refuse("can not decrement balance below zero")
+		}
+		return 0, refuse(fmt.Sprintf("can not decrement balance below the minimum of %.2f", min))
+	}
+
+	return fee, nil
+}
+
+// pendingTransaction is a transaction waiting to be posted to an account.
+type pendingTransaction struct {
+	Username    string
+	Account     string
+	Amount      float32
+	Name        string
+	Description string
+	Category    string
+	Tags        []string
+	Recurring   bool
+	// PayeeID is the payee the transaction was matched to, if any.
+	PayeeID *int
+}
+
+// postTransaction checks p against the rules of its account and inserts it
+// within tx, followed by an overdraft fee when one is due. Posts to the same
+// account are serialized for the rest of tx so the balance check can not race.
+// It returns the id of the new transaction and the resulting balance.
+func postTransaction(ctx context.Context, tx *sql.Tx, p pendingTransaction) (int, float32, error) {
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1 || '/' || $2))`, p.Username, p.Account)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if err := checkWritable(ctx, tx, p.Username, p.Account); err != nil {
+		return 0, 0, err
+	}
+
+	var balance float32
+	query := `SELECT COALESCE(SUM(TransactionAmount), 0) FROM mrkrabs.Transactions
+	WHERE Username = $1 AND accountname = $2`
+	if err := tx.QueryRowContext(ctx, query, p.Username, p.Account).Scan(&balance); err != nil {
+		return 0, 0, err
+	}
+
+	acct, err := accountOrDefault(ctx, tx, p.Username, p.Account)
+	if err != nil {
+		return 0, 0, err
+	}
+	fee, err := acct.checkTransaction(ctx, tx, balance, p.Amount, p.Recurring)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if systemCategories[p.Category] {
+		if err := ensureCategory(ctx, tx, p.Username, p.Account, p.Category); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	insert := `insert into mrkrabs.Transactions (Username, AccountName, TransactionAmount, TransactionName, TransactionDescription, Category, PayeeID) values
+	($1,$2,$3,$4,$5,$6,$7)
+	RETURNING TransactionID`
+
+	var transactionID int
+	row := tx.QueryRowContext(ctx, insert, p.Username, p.Account, p.Amount, p.Name, p.Description, p.Category, p.PayeeID)
+	if err := row.Scan(&transactionID); err != nil {
+		return 0, 0, err
+	}
+	balance += p.Amount
+
+	if err := addTags(ctx, tx, transactionID, p.Tags); err != nil {
+		return 0, 0, err
+	}
+
+	if fee > 0 {
+		if err := ensureCategory(ctx, tx, p.Username, p.Account, CategoryFees); err != nil {
+			return 0, 0, err
+		}
+		_, err := tx.ExecContext(ctx, insert, p.Username, p.Account, -fee, "overdraft fee", fmt.Sprintf("overdraft fee for transaction %d", transactionID), CategoryFees, nil)
+		if err != nil {
+			return 0, 0, err
+		}
+		balance -= fee
+	}
+
+	return transactionID, balance, nil
+}
+
+func validatePolicy(policy BalancePolicy) error {
+	if policy.OverdraftLimit < 0 {
+		return errors.New("overdraft limit can not be negative")
+	}
+	if policy.OverdraftFee < 0 {
+		return errors.New("overdraft fee can not be negative")
+	}
+	return nil
+}
+
+func (t *Account) UpdateBalancePolicy(email string, account_name string, policy BalancePolicy) (Account, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if err := validatePolicy(policy); err != nil {
+		return Account{}, err
+	}
+	if err := checkWritable(ctx, db, email, account_name); err != nil {
+		return Account{}, err
+	}
+
+	query := `UPDATE mrkrabs.Account
+	SET minimumbalance = $1, overdraftlimit = $2, overdraftfee = $3, recurringmayoverdraw = $4
+	WHERE username = $5 AND accountname = $6
+	RETURNING ` + accountColumns
+
+	return scanAccount(db.QueryRowContext(ctx, query, policy.MinimumBalance, policy.OverdraftLimit, policy.OverdraftFee, policy.RecurringMayOverdraw, email, account_name))
+}
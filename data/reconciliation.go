@@ -0,0 +1,462 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	ClearedStatusUncleared  = "uncleared"
+	ClearedStatusCleared    = "cleared"
+	ClearedStatusReconciled = "reconciled"
+)
+
+// ErrTransactionReconciled is returned when changing a transaction of a locked
+// reconciliation.
+var ErrTransactionReconciled = errors.New("transaction is reconciled, unlock its reconciliation to change it")
+
+// Reconciliation checks the transactions of an account against a bank
+// statement. Transactions are ticked off until the cleared balance matches
+// the balance on the statement, then the reconciliation is locked and its
+// transactions become reconciled.
+type Reconciliation struct {
+	ReconciliationID int                         `json:"reconciliationID"`
+	AccountName      string                      `json:"accountname"`
+	StatementDate    time.Time                   `json:"statementDate"`
+	StatementBalance float32                     `json:"statementBalance"`
+	ClearedBalance   float32                     `json:"clearedBalance"`
+	Difference       float32                     `json:"difference"`
+	CreatedAt        time.Time                   `json:"createdAt"`
+	LockedAt         *time.Time                  `json:"lockedAt,omitempty"`
+	Transactions     []ReconciliationTransaction `json:"transactions,omitempty"`
+}
+
+// ReconciliationTransaction is a transaction that can be ticked off in an
+// open reconciliation.
+type ReconciliationTransaction struct {
+	Transaction
+	Ticked bool `json:"ticked"`
+}
+
+const reconciliationColumns = `reconciliationid, accountname, statementdate, statementbalance, COALESCE(clearedbalance, 0), createdat, lockedat`
+
+func scanReconciliation(row interface{ Scan(...any) error }) (Reconciliation, error) {
+	var r Reconciliation
+	err := row.Scan(&r.ReconciliationID, &r.AccountName, &r.StatementDate, &r.StatementBalance, &r.ClearedBalance, &r.CreatedAt, &r.LockedAt)
+	return r, err
+}
+
+// checkNotReconciled returns ErrTransactionReconciled when a transaction is
+// reconciled.
+func checkNotReconciled(ctx context.Context, q querier, username string, account string, transactionID int) error {
+	query := `SELECT clearedstatus FROM mrkrabs.Transactions WHERE transactionid = $1 AND username = $2 AND accountname = $3`
+
+	var status string
+	err := q.QueryRowContext(ctx, query, transactionID, username, account).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("transaction %d not found", transactionID)
+	}
+	if err != nil {
+		return err
+	}
+	if status == ClearedStatusReconciled {
+		return fmt.Errorf("%w: %d", ErrTransactionReconciled, transactionID)
+	}
+	return nil
+}
+
+// getReconciliation reads a reconciliation of an account, locking its row
+// for the rest of tx.
+func getReconciliation(ctx context.Context, tx *sql.Tx, username string, account string, reconciliationID int) (Reconciliation, error) {
+	query := `SELECT ` + reconciliationColumns + ` FROM mrkrabs.Reconciliation
+	WHERE reconciliationid = $1 AND username = $2 AND accountname = $3
+	FOR UPDATE`
+
+	r, err := scanReconciliation(tx.QueryRowContext(ctx, query, reconciliationID, username, account))
+	if errors.Is(err, sql.ErrNoRows) {
+		return r, fmt.Errorf("reconciliation %d not found", reconciliationID)
+	}
+	return r, err
+}
+
+// clearedBalance works out the cleared balance of an open reconciliation:
+// every transaction reconciled by an earlier statement plus the ones ticked
+// off in it. Transactions of later statements that are still locked do not
+// count, so an older reconciliation can be unlocked and locked again.
+func (r *Reconciliation) clearedBalance(ctx context.Context, q querier, username string) error {
+	query := `SELECT COALESCE(SUM(t.transactionamount), 0) FROM mrkrabs.Transactions t
+	WHERE t.username = $1 AND t.accountname = $2
+		AND (t.reconciliationid = $4 OR (t.clearedstatus = $3 AND t.reconciliationid IN (
+			SELECT reconciliationid FROM mrkrabs.Reconciliation
+			WHERE username = $1 AND accountname = $2 AND (statementdate, reconciliationid) < ($5, $4))))`
+
+	err := q.QueryRowContext(ctx, query, username, r.AccountName, ClearedStatusReconciled, r.ReconciliationID, r.StatementDate).Scan(&r.ClearedBalance)
+	if err != nil {
+		return err
+	}
+	r.Difference = r.StatementBalance - r.ClearedBalance
+	return nil
+}
+
+func (r *Reconciliation) balanced() bool {
+	return math.Round(float64(r.Difference)*100) == 0
+}
+
+// SetCleared marks a transaction as cleared by the bank, or not. Reconciled
+// transactions can not be changed.
+func (t *Transaction) SetCleared(username string, account string, transactionID int, cleared bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if err := checkWritable(ctx, db, username, account); err != nil {
+		return err
+	}
+	if err := checkNotReconciled(ctx, db, username, account, transactionID); err != nil {
+		return err
+	}
+
+	status := ClearedStatusUncleared
+	if cleared {
+		status = ClearedStatusCleared
+	}
+	// unclearing also takes the transaction out of an open reconciliation
+	query := `UPDATE mrkrabs.Transactions
+	SET clearedstatus = $1, reconciliationid = CASE WHEN $2 THEN reconciliationid END
+	WHERE transactionid = $3 AND username = $4 AND accountname = $5 AND clearedstatus <> $6`
+	_, err := db.ExecContext(ctx, query, status, cleared, transactionID, username, account, ClearedStatusReconciled)
+	return err
+}
+
+func (r *Reconciliation) GetReconciliations(username string, account string) ([]Reconciliation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	query := `SELECT ` + reconciliationColumns + ` FROM mrkrabs.Reconciliation
+	WHERE username = $1 AND accountname = $2
+	ORDER BY statementdate DESC, reconciliationid DESC`
+
+	rows, err := db.QueryContext(ctx, query, username, account)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reconciliations []Reconciliation
+	for rows.Next() {
+		rec, err := scanReconciliation(rows)
+		if err != nil {
+			return reconciliations, err
+		}
+		reconciliations = append(reconciliations, rec)
+	}
+	if err = rows.Err(); err != nil {
+		return reconciliations, err
+	}
+
+	for i := range reconciliations {
+		rec := &reconciliations[i]
+		if rec.LockedAt == nil {
+			if err := rec.clearedBalance(ctx, db, username); err != nil {
+				return reconciliations, err
+			}
+			continue
+		}
+		rec.Difference = rec.StatementBalance - rec.ClearedBalance
+	}
+	return reconciliations, nil
+}
+
+// GetReconciliation returns a reconciliation. An open one comes with the
+// transactions that can be ticked off: those up to the statement date that
+// are not reconciled yet. A locked one comes with the transactions it
+// reconciled.
+func (r *Reconciliation) GetReconciliation(username string, account string, reconciliationID int) (Reconciliation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return Reconciliation{}, err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT ` + reconciliationColumns + ` FROM mrkrabs.Reconciliation
+	WHERE reconciliationid = $1 AND username = $2 AND accountname = $3`
+	rec, err := scanReconciliation(tx.QueryRowContext(ctx, query, reconciliationID, username, account))
+	if errors.Is(err, sql.ErrNoRows) {
+		return rec, fmt.Errorf("reconciliation %d not found", reconciliationID)
+	}
+	if err != nil {
+		return rec, err
+	}
+
+	if rec.LockedAt == nil {
+		if err := rec.clearedBalance(ctx, tx, username); err != nil {
+			return rec, err
+		}
+	} else {
+		rec.Difference = rec.StatementBalance - rec.ClearedBalance
+	}
+
+	query = `SELECT TransactionID, username, transactionamount, transactionname, transactiondescription, category, transactiondate, clearedstatus,
+		reconciliationid IS NOT DISTINCT FROM $3
+	FROM mrkrabs.Transactions
+	WHERE username = $1 AND accountname = $2
+		AND (reconciliationid = $3 OR ($4 AND clearedstatus <> $5 AND transactiondate < $6))
+	ORDER BY transactiondate, TransactionID`
+
+	rows, err := tx.QueryContext(ctx, query, username, account, rec.ReconciliationID, rec.LockedAt == nil, ClearedStatusReconciled, rec.StatementDate.AddDate(0, 0, 1))
+	if err != nil {
+		return rec, err
+	}
+	defer rows.Close()
+
+	rec.Transactions = []ReconciliationTransaction{}
+	for rows.Next() {
+		var rt ReconciliationTransaction
+		if err := rows.Scan(&rt.TransactionID, &rt.UserID, &rt.TransactionAmount, &rt.TransactionName, &rt.TransactionDescription, &rt.TransactionCategory, &rt.TransactionDate, &rt.ClearedStatus,
+			&rt.Ticked); err != nil {
+			return rec, err
+		}
+		rec.Transactions = append(rec.Transactions, rt)
+	}
+	if err = rows.Err(); err != nil {
+		return rec, err
+	}
+	return rec, nil
+}
+
+// CreateReconciliation opens a reconciliation against a statement. An account
+// has at most one open reconciliation. Transactions up to the statement date
+// that are already cleared start ticked off.
+func (r *Reconciliation) CreateReconciliation(username string, account string, statementDate time.Time, statementBalance float32) (Reconciliation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Reconciliation{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1 || '/' || $2))`, username, account)
+	if err != nil {
+		return Reconciliation{}, err
+	}
+	if err := checkWritable(ctx, tx, username, account); err != nil {
+		return Reconciliation{}, err
+	}
+	acct, err := accountOrDefault(ctx, tx, username, account)
+	if err != nil {
+		return Reconciliation{}, err
+	}
+	if acct.SkipsReconciliation() {
+		return Reconciliation{}, fmt.Errorf("%s accounts are not reconciled", acct.AccountType)
+	}
+
+	var open bool
+	query := `SELECT EXISTS (SELECT 1 FROM mrkrabs.Reconciliation WHERE username = $1 AND accountname = $2 AND lockedat IS NULL)`
+	if err := tx.QueryRowContext(ctx, query, username, account).Scan(&open); err != nil {
+		return Reconciliation{}, err
+	}
+	if open {
+		return Reconciliation{}, errors.New("account already has an open reconciliation")
+	}
+
+	query = `INSERT INTO mrkrabs.Reconciliation (username, accountname, statementdate, statementbalance)
+	VALUES ($1, $2, $3, $4)
+	RETURNING ` + reconciliationColumns
+	rec, err := scanReconciliation(tx.QueryRowContext(ctx, query, username, account, statementDate, statementBalance))
+	if err != nil {
+		return rec, err
+	}
+
+	query = `UPDATE mrkrabs.Transactions SET reconciliationid = $1
+	WHERE username = $2 AND accountname = $3 AND clearedstatus = $4 AND transactiondate < $5`
+	_, err = tx.ExecContext(ctx, query, rec.ReconciliationID, username, account, ClearedStatusCleared, statementDate.AddDate(0, 0, 1))
+	if err != nil {
+		return rec, err
+	}
+
+	if err := rec.clearedBalance(ctx, tx, username); err != nil {
+		return rec, err
+	}
+	return rec, tx.Commit()
+}
+
+// TickTransactions ticks transactions off an open reconciliation, which marks
+// them as cleared, or takes them out of it again.
+func (r *Reconciliation) TickTransactions(username string, account string, reconciliationID int, transactionIDs []int, ticked bool) (Reconciliation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Reconciliation{}, err
+	}
+	defer tx.Rollback()
+
+	rec, err := getReconciliation(ctx, tx, username, account, reconciliationID)
+	if err != nil {
+		return rec, err
+	}
+	if rec.LockedAt != nil {
+		return rec, fmt.Errorf("reconciliation %d is locked", reconciliationID)
+	}
+
+	tick := `UPDATE mrkrabs.Transactions SET reconciliationid = $1, clearedstatus = $2
+	WHERE transactionid = $3 AND username = $4 AND accountname = $5 AND transactiondate < $6`
+	untick := `UPDATE mrkrabs.Transactions SET reconciliationid = NULL, clearedstatus = $1
+	WHERE transactionid = $2 AND username = $3 AND accountname = $4 AND reconciliationid = $5`
+	for _, id := range transactionIDs {
+		if err := checkNotReconciled(ctx, tx, username, account, id); err != nil {
+			return rec, err
+		}
+
+		var res sql.Result
+		if ticked {
+			res, err = tx.ExecContext(ctx, tick, rec.ReconciliationID, ClearedStatusCleared, id, username, account, rec.StatementDate.AddDate(0, 0, 1))
+		} else {
+			res, err = tx.ExecContext(ctx, untick, ClearedStatusUncleared, id, username, account, rec.ReconciliationID)
+		}
+		if err != nil {
+			return rec, err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return rec, err
+		} else if n == 0 && ticked {
+			return rec, fmt.Errorf("transaction %d is after the statement date", id)
+		}
+	}
+
+	if err := rec.clearedBalance(ctx, tx, username); err != nil {
+		return rec, err
+	}
+	return rec, tx.Commit()
+}
+
+// LockReconciliation finishes a reconciliation once its cleared balance
+// matches the statement. Its transactions become reconciled and can not be
+// changed until it is unlocked.
+func (r *Reconciliation) LockReconciliation(username string, account string, reconciliationID int) (Reconciliation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Reconciliation{}, err
+	}
+	defer tx.Rollback()
+
+	rec, err := getReconciliation(ctx, tx, username, account, reconciliationID)
+	if err != nil {
+		return rec, err
+	}
+	if rec.LockedAt != nil {
+		return rec, fmt.Errorf("reconciliation %d is already locked", reconciliationID)
+	}
+	if err := rec.clearedBalance(ctx, tx, username); err != nil {
+		return rec, err
+	}
+	if !rec.balanced() {
+		return rec, fmt.Errorf("cleared balance is %.2f off the statement balance", rec.Difference)
+	}
+
+	query := `UPDATE mrkrabs.Transactions SET clearedstatus = $1 WHERE reconciliationid = $2`
+	if _, err := tx.ExecContext(ctx, query, ClearedStatusReconciled, rec.ReconciliationID); err != nil {
+		return rec, err
+	}
+	query = `UPDATE mrkrabs.Reconciliation SET lockedat = now(), clearedbalance = $1 WHERE reconciliationid = $2
+	RETURNING ` + reconciliationColumns
+	cleared := rec.ClearedBalance
+	rec, err = scanReconciliation(tx.QueryRowContext(ctx, query, cleared, rec.ReconciliationID))
+	if err != nil {
+		return rec, err
+	}
+	rec.Difference = rec.StatementBalance - rec.ClearedBalance
+	return rec, tx.Commit()
+}
+
+// UnlockReconciliation opens a locked reconciliation again so its
+// transactions can be changed. They go back to being cleared and stay ticked
+// off. An account with another open reconciliation can not unlock one.
+func (r *Reconciliation) UnlockReconciliation(username string, account string, reconciliationID int) (Reconciliation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Reconciliation{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1 || '/' || $2))`, username, account)
+	if err != nil {
+		return Reconciliation{}, err
+	}
+	rec, err := getReconciliation(ctx, tx, username, account, reconciliationID)
+	if err != nil {
+		return rec, err
+	}
+	if rec.LockedAt == nil {
+		return rec, fmt.Errorf("reconciliation %d is not locked", reconciliationID)
+	}
+
+	var open bool
+	query := `SELECT EXISTS (SELECT 1 FROM mrkrabs.Reconciliation WHERE username = $1 AND accountname = $2 AND lockedat IS NULL)`
+	if err := tx.QueryRowContext(ctx, query, username, account).Scan(&open); err != nil {
+		return rec, err
+	}
+	if open {
+		return rec, errors.New("finish or delete the open reconciliation of the account first")
+	}
+
+	query = `UPDATE mrkrabs.Transactions SET clearedstatus = $1 WHERE reconciliationid = $2`
+	if _, err := tx.ExecContext(ctx, query, ClearedStatusCleared, rec.ReconciliationID); err != nil {
+		return rec, err
+	}
+	query = `UPDATE mrkrabs.Reconciliation SET lockedat = NULL, clearedbalance = NULL WHERE reconciliationid = $1
+	RETURNING ` + reconciliationColumns
+	rec, err = scanReconciliation(tx.QueryRowContext(ctx, query, rec.ReconciliationID))
+	if err != nil {
+		return rec, err
+	}
+	if err := rec.clearedBalance(ctx, tx, username); err != nil {
+		return rec, err
+	}
+	return rec, tx.Commit()
+}
+
+// DeleteReconciliation drops an open reconciliation. Its transactions stay
+// cleared.
+func (r *Reconciliation) DeleteReconciliation(username string, account string, reconciliationID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rec, err := getReconciliation(ctx, tx, username, account, reconciliationID)
+	if err != nil {
+		return err
+	}
+	if rec.LockedAt != nil {
+		return fmt.Errorf("reconciliation %d is locked, unlock it first", reconciliationID)
+	}
+
+	query := `UPDATE mrkrabs.Transactions SET reconciliationid = NULL WHERE reconciliationid = $1`
+	if _, err := tx.ExecContext(ctx, query, rec.ReconciliationID); err != nil {
+		return err
+	}
+	query = `DELETE FROM mrkrabs.Reconciliation WHERE reconciliationid = $1`
+	if _, err := tx.ExecContext(ctx, query, rec.ReconciliationID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,374 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgtype"
+)
+
+const (
+	RuleFieldName        = "name"
+	RuleFieldDescription = "description"
+	RuleFieldAny         = "any"
+
+	RuleMatchContains = "contains"
+	RuleMatchRegex    = "regex"
+)
+
+// Rule categorizes, renames and tags the transactions posted to an account
+// that match it. Rules run in ascending priority order.
+type Rule struct {
+	RuleID      int      `json:"ruleID"`
+	Username    string   `json:"username"`
+	AccountName string   `json:"accountname"`
+	Priority    int      `json:"priority"`
+	MatchField  string   `json:"matchField"`
+	MatchType   string   `json:"matchType"`
+	Pattern     string   `json:"pattern"`
+	MinAmount   *float32 `json:"minAmount,omitempty"`
+	MaxAmount   *float32 `json:"maxAmount,omitempty"`
+	SetCategory string   `json:"setCategory"`
+	RenameTo    string   `json:"renameTo"`
+	AddTags     []string `json:"addTags"`
+	Enabled     bool     `json:"enabled"`
+
+	re *regexp.Regexp
+}
+
+// RuleChange is what re-applying the rules did to one transaction.
+type RuleChange struct {
+	TransactionID int      `json:"transaction_id"`
+	OldName       string   `json:"oldName"`
+	NewName       string   `json:"newName"`
+	OldCategory   string   `json:"oldCategory"`
+	NewCategory   string   `json:"newCategory"`
+	AddedTags     []string `json:"addedTags,omitempty"`
+}
+
+const ruleColumns = `ruleid, username, accountname, priority, matchfield, matchtype, pattern, minamount, maxamount, setcategory, renameto, addtags, enabled`
+
+func scanRule(row interface{ Scan(...any) error }) (Rule, error) {
+	var r Rule
+	var tags pgtype.TextArray
+
+	err := row.Scan(&r.RuleID, &r.Username, &r.AccountName, &r.Priority, &r.MatchField, &r.MatchType, &r.Pattern, &r.MinAmount, &r.MaxAmount, &r.SetCategory, &r.RenameTo, &tags, &r.Enabled)
+	if err != nil {
+		return r, err
+	}
+	if err := tags.AssignTo(&r.AddTags); err != nil {
+		return r, err
+	}
+	return r, r.compile()
+}
+
+func (r *Rule) compile() error {
+	if r.AddTags == nil {
+		r.AddTags = []string{}
+	}
+
+	switch r.MatchField {
+	case RuleFieldName, RuleFieldDescription, RuleFieldAny:
+	default:
+		return fmt.Errorf("unknown match field %q", r.MatchField)
+	}
+
+	switch r.MatchType {
+	case RuleMatchContains:
+		r.re = nil
+	case RuleMatchRegex:
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %w", err)
+		}
+		r.re = re
+	default:
+		return fmt.Errorf("unknown match type %q", r.MatchType)
+	}
+
+	if r.Pattern == "" && r.MinAmount == nil && r.MaxAmount == nil {
+		return errors.New("a rule needs a pattern or an amount range")
+	}
+	if r.MinAmount != nil && r.MaxAmount != nil && *r.MinAmount > *r.MaxAmount {
+		return errors.New("minimum amount can not be above maximum amount")
+	}
+	if r.SetCategory == "" && r.RenameTo == "" && len(r.AddTags) == 0 {
+		return errors.New("a rule needs at least one action")
+	}
+	return nil
+}
+
+func (r *Rule) matchText(s string) bool {
+	if r.Pattern == "" {
+		return true
+	}
+	if r.re != nil {
+		return r.re.MatchString(s)
+	}
+	return strings.Contains(strings.ToLower(s), strings.ToLower(r.Pattern))
+}
+
+// Matches reports whether a transaction with the given name, description and
+// amount is matched by the rule. Substring matches ignore case.
+func (r *Rule) Matches(name string, description string, amount float32) bool {
+	if !r.Enabled {
+		return false
+	}
+	if r.MinAmount != nil && amount < *r.MinAmount {
+		return false
+	}
+	if r.MaxAmount != nil && amount > *r.MaxAmount {
+		return false
+	}
+
+	switch r.MatchField {
+	case RuleFieldName:
+		return r.matchText(name)
+	case RuleFieldDescription:
+		return r.matchText(description)
+	}
+	return r.matchText(name) || r.matchText(description)
+}
+
+// applyRules runs rules, sorted by priority, over a transaction. The first
+// matching rule to set a category or a new name wins; tags from every
+// matching rule are collected. The category is only set when the transaction
+// has none, unless overwrite is set.
+func applyRules(rules []Rule, name string, description string, category string, amount float32, overwrite bool) (string, string, []string) {
+	renamed, categorized := false, category != "" && !overwrite
+	var tags []string
+	seen := map[string]bool{}
+
+	for i := range rules {
+		r := &rules[i]
+		if !r.Matches(name, description, amount) {
+			continue
+		}
+		if r.SetCategory != "" && !categorized {
+			category, categorized = r.SetCategory, true
+		}
+		if r.RenameTo != "" && !renamed {
+			name, renamed = r.RenameTo, true
+		}
+		for _, tag := range r.AddTags {
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return name, category, tags
+}
+
+func getRules(ctx context.Context, q querier, username string, account string) ([]Rule, error) {
+	query := `SELECT ` + ruleColumns + ` FROM mrkrabs.Rule
+	WHERE username = $1 AND accountname = $2 ORDER BY priority, ruleid`
+
+	rows, err := q.QueryContext(ctx, query, username, account)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []Rule
+	for rows.Next() {
+		rule, err := scanRule(rows)
+		if err != nil {
+			return rules, err
+		}
+		rules = append(rules, rule)
+	}
+	if err = rows.Err(); err != nil {
+		return rules, err
+	}
+	return rules, nil
+}
+
+// addTags attaches tags to a transaction, ignoring ones it already has.
+func addTags(ctx context.Context, tx *sql.Tx, transactionID int, tags []string) error {
+	query := `INSERT INTO mrkrabs.TransactionTag (transactionid, tag) VALUES ($1, $2)
+	ON CONFLICT DO NOTHING`
+
+	for _, tag := range tags {
+		if _, err := tx.ExecContext(ctx, query, transactionID, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Rule) GetRules(username string, account string) ([]Rule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return getRules(ctx, db, username, account)
+}
+
+func (r *Rule) CreateRule(username string, account string, rule Rule) (Rule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if err := rule.compile(); err != nil {
+		return Rule{}, err
+	}
+	if err := checkWritable(ctx, db, username, account); err != nil {
+		return Rule{}, err
+	}
+	if err := validateCategory(ctx, db, username, account, rule.SetCategory); err != nil {
+		return Rule{}, err
+	}
+
+	query := `INSERT INTO mrkrabs.Rule (username, accountname, priority, matchfield, matchtype, pattern, minamount, maxamount, setcategory, renameto, addtags, enabled)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	RETURNING ` + ruleColumns
+
+	return scanRule(db.QueryRowContext(ctx, query, username, account, rule.Priority, rule.MatchField, rule.MatchType, rule.Pattern, rule.MinAmount, rule.MaxAmount, rule.SetCategory, rule.RenameTo, rule.AddTags, rule.Enabled))
+}
+
+func (r *Rule) UpdateRule(username string, account string, ruleID int, rule Rule) (Rule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if err := rule.compile(); err != nil {
+		return Rule{}, err
+	}
+	if err := checkWritable(ctx, db, username, account); err != nil {
+		return Rule{}, err
+	}
+	if err := validateCategory(ctx, db, username, account, rule.SetCategory); err != nil {
+		return Rule{}, err
+	}
+
+	query := `UPDATE mrkrabs.Rule
+	SET priority = $1, matchfield = $2, matchtype = $3, pattern = $4, minamount = $5, maxamount = $6, setcategory = $7, renameto = $8, addtags = $9, enabled = $10
+	WHERE ruleid = $11 AND username = $12 AND accountname = $13
+	RETURNING ` + ruleColumns
+
+	updated, err := scanRule(db.QueryRowContext(ctx, query, rule.Priority, rule.MatchField, rule.MatchType, rule.Pattern, rule.MinAmount, rule.MaxAmount, rule.SetCategory, rule.RenameTo, rule.AddTags, rule.Enabled, ruleID, username, account))
+	if errors.Is(err, sql.ErrNoRows) {
+		return updated, fmt.Errorf("rule %d not found", ruleID)
+	}
+	return updated, err
+}
+
+func (r *Rule) DeleteRule(username string, account string, ruleID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	query := `DELETE FROM mrkrabs.Rule WHERE ruleid = $1 AND username = $2 AND accountname = $3`
+
+	res, err := db.ExecContext(ctx, query, ruleID, username, account)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("rule %d not found", ruleID)
+	}
+	return nil
+}
+
+// ApplyRulesToHistory runs the rules of an account over all of its existing
+// transactions and returns what changed. Categories that are already set are
+// only replaced when overwrite is set. Nothing is saved when dryRun is set.
+// Reconciled transactions are left alone.
+func (r *Rule) ApplyRulesToHistory(username string, account string, overwrite bool, dryRun bool) ([]RuleChange, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout*10)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := checkWritable(ctx, tx, username, account); err != nil {
+		return nil, err
+	}
+	rules, err := getRules(ctx, tx, username, account)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT t.transactionid, t.transactionname, t.transactiondescription, t.category, t.transactionamount,
+		COALESCE((SELECT array_agg(tag) FROM mrkrabs.TransactionTag tt WHERE tt.transactionid = t.transactionid), '{}')
+	FROM mrkrabs.Transactions t
+	WHERE t.username = $1 AND t.accountname = $2 AND t.clearedstatus <> $3
+	ORDER BY t.transactionid`
+
+	rows, err := tx.QueryContext(ctx, query, username, account, ClearedStatusReconciled)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []RuleChange
+	for rows.Next() {
+		var id int
+		var name, description, category string
+		var amount float32
+		var current pgtype.TextArray
+		if err := rows.Scan(&id, &name, &description, &category, &amount, &current); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		var have []string
+		if err := current.AssignTo(&have); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		newName, newCategory, tags := applyRules(rules, name, description, category, amount, overwrite)
+
+		var added []string
+		for _, tag := range tags {
+			if !containsString(have, tag) {
+				added = append(added, tag)
+			}
+		}
+		if newName == name && newCategory == category && len(added) == 0 {
+			continue
+		}
+		changes = append(changes, RuleChange{
+			TransactionID: id,
+			OldName:       name,
+			NewName:       newName,
+			OldCategory:   category,
+			NewCategory:   newCategory,
+			AddedTags:     added,
+		})
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if dryRun {
+		return changes, nil
+	}
+
+	update := `UPDATE mrkrabs.Transactions SET transactionname = $1, category = $2 WHERE transactionid = $3`
+	for _, c := range changes {
+		if _, err := tx.ExecContext(ctx, update, c.NewName, c.NewCategory, c.TransactionID); err != nil {
+			return nil, err
+		}
+		if err := addTags(ctx, tx, c.TransactionID, c.AddedTags); err != nil {
+			return nil, err
+		}
+	}
+	return changes, tx.Commit()
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Split is one line of a transaction spread over several categories. The
+// lines of a transaction add up to its amount.
+type Split struct {
+	SplitID  int     `json:"splitID"`
+	Amount   float32 `json:"amount"`
+	Category string  `json:"category"`
+	Memo     string  `json:"memo"`
+}
+
+// categoryLines stands in for mrkrabs.Transactions in category reports. A
+// split transaction is replaced by its lines, each with its own amount and
+// category, and described by its memo when it has one.
+const categoryLines = `(SELECT t.transactionid, t.username, t.accountname, t.transactiondate, t.transactionname, t.clearedstatus,
+		CASE WHEN s.memo IS NULL OR s.memo = '' THEN t.transactiondescription ELSE s.memo END AS transactiondescription,
+		COALESCE(s.category, t.category) AS category,
+		COALESCE(s.amount, t.transactionamount) AS transactionamount
+	FROM mrkrabs.Transactions t
+	LEFT JOIN mrkrabs.TransactionSplit s ON s.transactionid = t.transactionid)`
+
+// transactionSplitsColumn selects the split lines of the transaction t as a
+// JSON array.
+const transactionSplitsColumn = `COALESCE((SELECT json_agg(json_build_object('splitID', s.splitid, 'amount', s.amount, 'category', s.category, 'memo', s.memo)
+		ORDER BY s.splitid) FROM mrkrabs.TransactionSplit s WHERE s.transactionid = t.TransactionID), '[]')`
+
+func (t *Transaction) GetSplits(username string, account string, transactionID int) ([]Split, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	query := `SELECT s.splitid, s.amount, s.category, s.memo
+	FROM mrkrabs.TransactionSplit s
+	JOIN mrkrabs.Transactions t ON t.transactionid = s.transactionid
+	WHERE s.transactionid = $1 AND t.username = $2 AND t.accountname = $3
+	ORDER BY s.splitid`
+
+	rows, err := db.QueryContext(ctx, query, transactionID, username, account)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	splits := []Split{}
+	for rows.Next() {
+		var s Split
+		if err := rows.Scan(&s.SplitID, &s.Amount, &s.Category, &s.Memo); err != nil {
+			return splits, err
+		}
+		splits = append(splits, s)
+	}
+	if err = rows.Err(); err != nil {
+		return splits, err
+	}
+	return splits, nil
+}
+
+// SetSplits replaces the split lines of a transaction. A transaction is split
+// into at least two lines whose amounts add up to its own; no lines at all
+// remove the split. Reconciled transactions can not be split.
+func (t *Transaction) SetSplits(username string, account string, transactionID int, splits []Split) ([]Split, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if len(splits) == 1 {
+		return nil, errors.New("a transaction is split into at least two lines")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := checkWritable(ctx, tx, username, account); err != nil {
+		return nil, err
+	}
+	if err := checkNotReconciled(ctx, tx, username, account, transactionID); err != nil {
+		return nil, err
+	}
+
+	var amount float32
+	query := `SELECT transactionamount FROM mrkrabs.Transactions
+	WHERE transactionid = $1 AND username = $2 AND accountname = $3
+	FOR UPDATE`
+	if err := tx.QueryRowContext(ctx, query, transactionID, username, account).Scan(&amount); err != nil {
+		return nil, err
+	}
+
+	var total float64
+	for _, s := range splits {
+		if s.Amount == 0 {
+			return nil, errors.New("split lines need an amount")
+		}
+		if err := validateCategory(ctx, tx, username, account, s.Category); err != nil {
+			return nil, err
+		}
+		total += float64(s.Amount)
+	}
+	if len(splits) > 0 && math.Round(total*100) != math.Round(float64(amount)*100) {
+		return nil, fmt.Errorf("split lines add up to %.2f instead of %.2f", total, amount)
+	}
+
+	query = `DELETE FROM mrkrabs.TransactionSplit WHERE transactionid = $1`
+	if _, err := tx.ExecContext(ctx, query, transactionID); err != nil {
+		return nil, err
+	}
+
+	insert := `INSERT INTO mrkrabs.TransactionSplit (transactionid, amount, category, memo) VALUES ($1, $2, $3, $4)
+	RETURNING splitid`
+	saved := []Split{}
+	for _, s := range splits {
+		if err := tx.QueryRowContext(ctx, insert, transactionID, s.Amount, s.Category, s.Memo).Scan(&s.SplitID); err != nil {
+			return nil, err
+		}
+		saved = append(saved, s)
+	}
+	return saved, tx.Commit()
+}
@@ -0,0 +1,115 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Statement summarizes the transactions of an account over a period. Outflows
+// are given as positive amounts.
+type Statement struct {
+	Username       string              `json:"username"`
+	AccountName    string              `json:"accountname"`
+	From           time.Time           `json:"from"`
+	To             time.Time           `json:"to"`
+	OpeningBalance float32             `json:"openingBalance"`
+	Inflows        float32             `json:"inflows"`
+	Outflows       float32             `json:"outflows"`
+	ClosingBalance float32             `json:"closingBalance"`
+	Categories     []StatementCategory `json:"categories"`
+	Transactions   []Transaction       `json:"transactions"`
+}
+
+// StatementCategory is the part of a statement in one category.
+type StatementCategory struct {
+	Category string  `json:"category"`
+	Inflows  float32 `json:"inflows"`
+	Outflows float32 `json:"outflows"`
+	Net      float32 `json:"net"`
+	Count    int     `json:"count"`
+}
+
+// GetStatement returns the statement of an account for the transactions dated
+// from up to, but not including, to.
+func (t *Transaction) GetStatement(username string, account string, from time.Time, to time.Time) (Statement, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if !from.Before(to) {
+		return Statement{}, errors.New("statement period must end after it starts")
+	}
+
+	s := Statement{
+		Username:     username,
+		AccountName:  account,
+		From:         from,
+		To:           to,
+		Categories:   []StatementCategory{},
+		Transactions: []Transaction{},
+	}
+
+	query := `SELECT COALESCE(SUM(transactionamount), 0) FROM mrkrabs.Transactions
+	WHERE username = $1 AND accountname = $2 AND transactiondate < $3`
+	if err := db.QueryRowContext(ctx, query, username, account, from).Scan(&s.OpeningBalance); err != nil {
+		return s, err
+	}
+
+	query = `SELECT TransactionID, username, transactionamount, transactionname, transactiondescription, category, transactiondate
+	FROM mrkrabs.Transactions
+	WHERE username = $1 AND accountname = $2 AND transactiondate >= $3 AND transactiondate < $4
+	ORDER BY transactiondate, TransactionID`
+
+	rows, err := db.QueryContext(ctx, query, username, account, from, to)
+	if err != nil {
+		return s, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var trans Transaction
+		if err := rows.Scan(&trans.TransactionID, &trans.UserID, &trans.TransactionAmount, &trans.TransactionName, &trans.TransactionDescription, &trans.TransactionCategory, &trans.TransactionDate); err != nil {
+			return s, err
+		}
+		s.Transactions = append(s.Transactions, trans)
+
+		if trans.TransactionAmount >= 0 {
+			s.Inflows += trans.TransactionAmount
+		} else {
+			s.Outflows -= trans.TransactionAmount
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return s, err
+	}
+
+	// categories count the lines of split transactions
+	query = `SELECT category,
+		COALESCE(SUM(transactionamount) FILTER (WHERE transactionamount >= 0), 0),
+		COALESCE(-SUM(transactionamount) FILTER (WHERE transactionamount < 0), 0),
+		SUM(transactionamount), COUNT(*)
+	FROM ` + categoryLines + ` t
+	WHERE username = $1 AND accountname = $2 AND transactiondate >= $3 AND transactiondate < $4
+	GROUP BY category
+	ORDER BY category`
+
+	catRows, err := db.QueryContext(ctx, query, username, account, from, to)
+	if err != nil {
+		return s, err
+	}
+	defer catRows.Close()
+
+	for catRows.Next() {
+		var c StatementCategory
+		if err := catRows.Scan(&c.Category, &c.Inflows, &c.Outflows, &c.Net, &c.Count); err != nil {
+			return s, err
+		}
+		s.Categories = append(s.Categories, c)
+	}
+	if err = catRows.Err(); err != nil {
+		return s, err
+	}
+
+	s.ClosingBalance = s.OpeningBalance + s.Inflows - s.Outflows
+	return s, nil
+}
@@ -0,0 +1,289 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxTagLength bounds the length of a tag.
+const maxTagLength = 64
+
+// TagTotal is the money that went through the transactions with one tag.
+// Expenses are given as a positive amount.
+type TagTotal struct {
+	Tag      string  `json:"tag"`
+	Income   float32 `json:"income"`
+	Expenses float32 `json:"expenses"`
+	Net      float32 `json:"net"`
+	Count    int     `json:"count"`
+}
+
+// transactionTagsColumn selects the tags of the transaction t.
+const transactionTagsColumn = `COALESCE((SELECT array_agg(g.tag ORDER BY g.tag) FROM mrkrabs.TransactionTag g
+		WHERE g.transactionid = t.TransactionID), '{}')`
+
+// cleanTags trims tags and drops repeated ones.
+func cleanTags(tags []string) ([]string, error) {
+	cleaned := []string{}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			return nil, errors.New("tags can not be empty")
+		}
+		if len(tag) > maxTagLength {
+			return nil, fmt.Errorf("tags can be at most %d characters", maxTagLength)
+		}
+		if !containsString(cleaned, tag) {
+			cleaned = append(cleaned, tag)
+		}
+	}
+	return cleaned, nil
+}
+
+// GetTagTotals returns every tag of an account with the totals of its
+// transactions between from and to. Either bound may be nil.
+func (t *Transaction) GetTagTotals(username string, account string, from *time.Time, to *time.Time) ([]TagTotal, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	query := `WITH tagged AS (
+		SELECT g.tag, t.transactionamount,
+			($3::timestamptz IS NULL OR t.transactiondate >= $3) AND ($4::timestamptz IS NULL OR t.transactiondate < $4) AS inrange
+		FROM mrkrabs.TransactionTag g
+		JOIN mrkrabs.Transactions t ON t.transactionid = g.transactionid
+		WHERE t.username = $1 AND t.accountname = $2
+	)
+	SELECT tag,
+		COALESCE(SUM(transactionamount) FILTER (WHERE inrange AND transactionamount > 0), 0),
+		COALESCE(-SUM(transactionamount) FILTER (WHERE inrange AND transactionamount < 0), 0),
+		COALESCE(SUM(transactionamount) FILTER (WHERE inrange), 0),
+		COUNT(*) FILTER (WHERE inrange)
+	FROM tagged
+	GROUP BY tag
+	ORDER BY tag`
+
+	rows, err := db.QueryContext(ctx, query, username, account, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := []TagTotal{}
+	for rows.Next() {
+		var total TagTotal
+		if err := rows.Scan(&total.Tag, &total.Income, &total.Expenses, &total.Net, &total.Count); err != nil {
+			return totals, err
+		}
+		totals = append(totals, total)
+	}
+	if err = rows.Err(); err != nil {
+		return totals, err
+	}
+	return totals, nil
+}
+
+// changeTags runs change on a transaction that can be changed and returns its
+// tags afterwards.
+func changeTags(username string, account string, transactionID int, change func(ctx context.Context, q execQuerier) error) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := checkWritable(ctx, tx, username, account); err != nil {
+		return nil, err
+	}
+	if err := checkNotReconciled(ctx, tx, username, account, transactionID); err != nil {
+		return nil, err
+	}
+	if err := change(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	query := `SELECT tag FROM mrkrabs.TransactionTag WHERE transactionid = $1 ORDER BY tag`
+	rows, err := tx.QueryContext(ctx, query, transactionID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return tags, tx.Commit()
+}
+
+// SetTransactionTags replaces the tags of a transaction.
+func (t *Transaction) SetTransactionTags(username string, account string, transactionID int, tags []string) ([]string, error) {
+	tags, err := cleanTags(tags)
+	if err != nil {
+		return nil, err
+	}
+	return changeTags(username, account, transactionID, func(ctx context.Context, q execQuerier) error {
+		query := `DELETE FROM mrkrabs.TransactionTag WHERE transactionid = $1 AND NOT (tag = ANY($2))`
+		if _, err := q.ExecContext(ctx, query, transactionID, tags); err != nil {
+			return err
+		}
+		return insertTags(ctx, q, transactionID, tags)
+	})
+}
+
+// AddTransactionTags adds tags to a transaction, keeping the ones it has.
+func (t *Transaction) AddTransactionTags(username string, account string, transactionID int, tags []string) ([]string, error) {
+	tags, err := cleanTags(tags)
+	if err != nil {
+		return nil, err
+	}
+	return changeTags(username, account, transactionID, func(ctx context.Context, q execQuerier) error {
+		return insertTags(ctx, q, transactionID, tags)
+	})
+}
+
+// RemoveTransactionTag takes a tag off a transaction.
+func (t *Transaction) RemoveTransactionTag(username string, account string, transactionID int, tag string) ([]string, error) {
+	return changeTags(username, account, transactionID, func(ctx context.Context, q execQuerier) error {
+		query := `DELETE FROM mrkrabs.TransactionTag WHERE transactionid = $1 AND tag = $2`
+		res, err := q.ExecContext(ctx, query, transactionID, tag)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("transaction %d is not tagged %s", transactionID, tag)
+		}
+		return nil
+	})
+}
+
+func insertTags(ctx context.Context, q execQuerier, transactionID int, tags []string) error {
+	query := `INSERT INTO mrkrabs.TransactionTag (transactionid, tag) VALUES ($1, $2)
+	ON CONFLICT DO NOTHING`
+
+	for _, tag := range tags {
+		if _, err := q.ExecContext(ctx, query, transactionID, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenameTag renames a tag on every transaction and rule of an account. A
+// transaction that already has the new tag keeps it once. It returns the
+// number of transactions renamed.
+func (t *Transaction) RenameTag(username string, account string, from string, to string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	cleaned, err := cleanTags([]string{to})
+	if err != nil {
+		return 0, err
+	}
+	to = cleaned[0]
+	if to == from {
+		return 0, fmt.Errorf("tag %s already has that name", from)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := checkWritable(ctx, tx, username, account); err != nil {
+		return 0, err
+	}
+
+	query := `DELETE FROM mrkrabs.TransactionTag g
+	USING mrkrabs.Transactions t
+	WHERE t.transactionid = g.transactionid AND t.username = $1 AND t.accountname = $2 AND g.tag = $3
+		AND EXISTS (SELECT 1 FROM mrkrabs.TransactionTag o WHERE o.transactionid = g.transactionid AND o.tag = $4 AND o.tag <> g.tag)`
+	removed, err := tx.ExecContext(ctx, query, username, account, from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	query = `UPDATE mrkrabs.TransactionTag g SET tag = $4
+	FROM mrkrabs.Transactions t
+	WHERE t.transactionid = g.transactionid AND t.username = $1 AND t.accountname = $2 AND g.tag = $3`
+	res, err := tx.ExecContext(ctx, query, username, account, from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	// a rule already adding the new tag would otherwise add it twice
+	query = `UPDATE mrkrabs.Rule SET addtags = array_replace(array_remove(addtags, $4), $3, $4)
+	WHERE username = $1 AND accountname = $2 AND $3 = ANY(addtags)`
+	if _, err := tx.ExecContext(ctx, query, username, account, from, to); err != nil {
+		return 0, err
+	}
+
+	merged, err := removed.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n+merged == 0 {
+		return 0, fmt.Errorf("tag %s not found", from)
+	}
+	return n + merged, tx.Commit()
+}
+
+// DeleteTag takes a tag off every transaction and rule of an account. It
+// returns the number of transactions that had it.
+func (t *Transaction) DeleteTag(username string, account string, tag string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := checkWritable(ctx, tx, username, account); err != nil {
+		return 0, err
+	}
+
+	query := `DELETE FROM mrkrabs.TransactionTag g
+	USING mrkrabs.Transactions t
+	WHERE t.transactionid = g.transactionid AND t.username = $1 AND t.accountname = $2 AND g.tag = $3`
+	res, err := tx.ExecContext(ctx, query, username, account, tag)
+	if err != nil {
+		return 0, err
+	}
+
+	query = `UPDATE mrkrabs.Rule SET addtags = array_remove(addtags, $3)
+	WHERE username = $1 AND accountname = $2 AND $3 = ANY(addtags)`
+	if _, err := tx.ExecContext(ctx, query, username, account, tag); err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("tag %s not found", tag)
+	}
+	return n, tx.Commit()
+}